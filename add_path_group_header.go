@@ -1,25 +1,267 @@
 package traefik_add_path_group_middleware
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const defaultHeaderName = "x-path-group"
+const (
+	defaultHeaderName      = "x-path-group"
+	defaultBlockStatusCode = http.StatusBadRequest
+	defaultDelimiter       = "/"
+	defaultAuditSampleRate = 1
+	defaultRedactionLabel  = "redacted"
+	defaultCompositeLabel  = "composite_id"
+	defaultEntropyMinLen   = 16
+	defaultInlineSeparator = "|"
+	defaultInlineMaxLength = 1024
+	defaultEncodeChars     = "%:;,="
+	defaultUnmatchedValue  = "/unmatched"
+	defaultOpaqueValue     = "/deep"
+	defaultSelfLabel       = labelNumericID
+	defaultAggregateLabel  = "aggregate"
+)
+
+// truncationMarker replaces the segments dropped past the configured group prefix depth
+const truncationMarker = "..."
+
+// destinationGroupHeaderName carries the path group of the WebDAV Destination header
+const destinationGroupHeaderName = "X-Destination-Group"
+
+// schemaMismatchHeaderName flags paths whose segments do not have the types declared by a route schema
+const schemaMismatchHeaderName = "X-Path-Schema-Mismatch"
+
+// middlewareNameHeaderName carries the name of the middleware instance that set the path group
+const middlewareNameHeaderName = "X-Path-Group-By"
+
+// Path metric headers carry the byte length and segment depth of the request path
+const (
+	pathLengthHeaderName = "X-Path-Length"
+	pathDepthHeaderName  = "X-Path-Depth"
+)
+
+// Suspicious path tagging
+const (
+	suspiciousHeaderName = "X-Path-Suspicious"
+	suspiciousTraversal  = "traversal"
+)
 
 // ID type labels
 const (
-	labelUUID      = "uuid"
-	labelNumericID = "numeric_id"
-	labelISODate   = "iso_date"
-	labelULID      = "ulid"
-	labelCUID      = "cuid"
-	labelCUID2     = "cuid2"
-	labelNanoID    = "nanoid"
-	labelFile      = "file"
-	labelSlug      = "slug"
+	labelUUID        = "uuid"
+	labelNumericID   = "numeric_id"
+	labelISODate     = "iso_date"
+	labelULID        = "ulid"
+	labelCUID        = "cuid"
+	labelCUID2       = "cuid2"
+	labelNanoID      = "nanoid"
+	labelFile        = "file"
+	labelSlug        = "slug"
+	labelBool        = "bool"
+	labelToken       = "token"
+	labelDate        = "date"
+	labelURL         = "url"
+	labelLocale      = "locale"
+	labelEtag        = "etag"
+	labelIMEI        = "imei"
+	labelAction      = "action"
+	labelIDList      = "id_list"
+	labelLang        = "lang"
+	labelRandom      = "random"
+	labelCursor      = "cursor"
+	labelStructID    = "structured_id"
+	labelTimezone    = "timezone"
+	labelRef         = "ref"
+	labelCode        = "code"
+	labelRange       = "range"
+	labelDevice      = "device_token"
+	labelObjectID    = "objectid"
+	labelEmpty       = "empty"
+	labelBase32      = "base32"
+	labelIBAN        = "iban"
+	labelID          = "id"
+	labelGeohash     = "geohash"
+	labelUnicode     = "unicode"
+	labelSignature   = "signature"
+	labelTraceID     = "trace_id"
+	labelSpanID      = "span_id"
+	labelMedia       = "media_type"
+	labelIndex       = "index"
+	labelPlaceholder = "placeholder"
+	labelTraversal   = "traversal"
+	labelHexKey      = "hex_key"
+)
+
+// idLabels are the labels of identifier schemes, collapsed to id when IDs are genericized
+var idLabels = map[string]bool{
+	labelUUID:      true,
+	labelNumericID: true,
+	labelULID:      true,
+	labelCUID:      true,
+	labelCUID2:     true,
+	labelNanoID:    true,
+	labelObjectID:  true,
+	labelIMEI:      true,
+	labelStructID:  true,
+	labelBase32:    true,
+	labelCursor:    true,
+	labelRandom:    true,
+	labelDevice:    true,
+	labelIBAN:      true,
+	labelTraceID:   true,
+	labelSpanID:    true,
+	labelHexKey:    true,
+}
+
+// genericLabel returns the generic form of an identifier scheme label, keeping the type of
+// prefix-mapped labels (e.g. user_uuid -> user_id). Returns false for structural labels.
+func genericLabel(label string) (string, bool) {
+	if idLabels[label] {
+		return labelID, true
+	}
+	for scheme := range idLabels {
+		if prefix, ok := strings.CutSuffix(label, "_"+scheme); ok && prefix != "" {
+			return prefix + "_" + labelID, true
+		}
+	}
+	return "", false
+}
+
+// legacyStarLabel is the single label emitted in legacy star mode
+const legacyStarLabel = "*"
+
+// legacyStarDetectors are the ActiveDetectors entries still labeling segments in legacy star mode
+var legacyStarDetectors = map[string]bool{"uuid": true, "numeric_id": true, "slug": true}
+
+// crossSegmentDetectors are the ActiveDetectors entries applied by the path walk rather than the single-segment
+// classification, so legacy star mode does not replace them
+var crossSegmentDetectors = map[string]bool{
+	"empty":             true,
+	"acme_challenge":    true,
+	"locale":            true,
+	"lang":              true,
+	"date_parts":        true,
+	"path_date":         true,
+	"timezone":          true,
+	"git_ref":           true,
+	"composite_numeric": true,
+	"traversal_run":     true,
+	"objectid":          true,
+	"signature":         true,
+	"oauth_state":       true,
+	"uppercase":         true,
+}
+
+// Output formats
+const (
+	outputFormatDefault = ""
+	outputFormatDatadog = "datadog"
+)
+
+// weakEtagPrefix marks a weak entity tag (W/"..."), which arrives as its own segment when split on "/"
+const weakEtagPrefix = "W"
+
+// minEtagLength is the minimum length of the opaque part of an entity tag
+const minEtagLength = 6
+
+// uuidVariantIndex is the position of the variant nibble in a dashed UUID
+const uuidVariantIndex = 19
+
+// imeiLength is the number of digits in an IMEI
+const imeiLength = 15
+
+// Payment card numbers (PANs) are 13 to 19 digits long
+const (
+	minCardNumberLength = 13
+	maxCardNumberLength = 19
+)
+
+// HEAD request behaviors
+const (
+	headBehaviorClassify = "classify"
+	headBehaviorSkip     = "skip"
+	headBehaviorCollapse = "collapse"
+)
+
+// Uppercase-only segment behaviors
+const (
+	uppercasePreserve  = "preserve"
+	uppercaseLabel     = "label"
+	uppercaseLowercase = "lowercase"
+)
+
+// Empty segment behaviors
+const (
+	emptySegmentDrop     = "drop"
+	emptySegmentPreserve = "preserve"
+	emptySegmentReject   = "reject"
+)
+
+// headProbeGroup is the fixed path group emitted for collapsed HEAD probes
+const headProbeGroup = "/head-probe"
+
+// typedIDSuffix is appended to the preserved prefix of typed prefixed IDs (e.g. cus_id)
+const typedIDSuffix = "_id"
+
+// minTypedIDBodyLength is the minimum body length for a <prefix>_<body> segment to be a typed ID
+const minTypedIDBodyLength = 6
+
+// minCursorLength is the minimum length of a base64 pagination cursor
+const minCursorLength = 12
+
+// minUnclassifiedLength is the minimum length of an unclassified segment reported for discovery
+const minUnclassifiedLength = 8
+
+// minAssetHashLength is the minimum length of a content hash stripped from asset names
+const minAssetHashLength = 6
+
+// minBase32Length is the minimum length of a base32 segment, the size of a common TOTP secret
+const minBase32Length = 16
+
+// minGeohashLength and minMixedGeohashLength bound short geohashes, so tokens like http2, h264 or web3 stay literal:
+// a geohash needs at least two digits at minGeohashLength, or a single one from minMixedGeohashLength on
+const (
+	minGeohashLength      = 5
+	minMixedGeohashLength = 6
+)
+
+// riskFreePositions is the number of leading segments not scored for cardinality risk, as they are usually fixed prefixes (e.g. /api/v1)
+const riskFreePositions = 2
+
+// maxLearnedGroups bounds the distinct groups remembered in learn mode; groups beyond it are not reported
+const maxLearnedGroups = 10000
+
+// overflowGroup replaces new groups once MaxDistinctGroups distinct groups have been emitted
+const overflowGroup = "/overflow"
+
+// nilUUID is the all-zero UUID, commonly used as a placeholder in test traffic
+const nilUUID = "00000000-0000-0000-0000-000000000000"
+
+// minPlaceholderRepeat is the minimum length of a repeated-digit placeholder (e.g. 000)
+const minPlaceholderRepeat = 3
+
+// minPlaceholderSequence is the minimum length of a sequential digit placeholder (e.g. 123456)
+const minPlaceholderSequence = 6
+
+// Well-known URI segments (RFC 8615)
+const (
+	wellKnownSegment     = ".well-known"
+	acmeChallengeSegment = "acme-challenge"
 )
 
 var (
@@ -50,183 +292,3016 @@ var (
 	slugPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 	// prefixPattern matches alphanumeric prefix (for prefixed IDs)
 	prefixPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	// hex32Pattern matches exactly 32 hex digits (a UUID with its dashes removed)
+	hex32Pattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+	// embeddedURLPattern matches decoded segments that are themselves absolute http(s) URLs
+	embeddedURLPattern = regexp.MustCompile(`^(?i:https?)://`)
+	// dateFileBasePattern matches file base names that are ISO-like dates or datetimes,
+	// including the dash-in-time variant used by filesystems that disallow colons (e.g., 2024-02-26T00-01-55)
+	dateFileBasePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt_]\d{2}[-:]\d{2}[-:]\d{2}(\.\d{1,9})?([Zz]|[+-]\d{2}:?\d{2})?)?$`)
+	// typedPrefixPattern matches Stripe-style typed IDs: <alnumprefix>_<base62 body containing a digit>
+	typedPrefixPattern = regexp.MustCompile(`^([a-zA-Z0-9]+)_([A-Za-z0-9]*[0-9][A-Za-z0-9]*)$`)
+	// twilioSIDPattern matches Twilio-style SIDs: two uppercase letters followed by 32 hex digits
+	twilioSIDPattern = regexp.MustCompile(`^([A-Z]{2})[0-9a-fA-F]{32}$`)
+	// localePattern matches language-region locale tags (e.g., en-US, pt_BR)
+	localePattern = regexp.MustCompile(`^[a-z]{2}[-_][A-Za-z]{2}$`)
+	// etagValuePattern matches the opaque part of an entity tag: hex digits, optionally dash-separated (e.g., S3 multipart etags)
+	etagValuePattern = regexp.MustCompile(`^[0-9a-fA-F]+(-[0-9a-fA-F]+)*$`)
+	// boolPattern matches common boolean literals, case-insensitively
+	boolPattern = regexp.MustCompile(`^(?i:true|false|yes|no|on|off)$`)
+	// structuredIDPattern matches human-readable structured IDs: prefix, year and sequence number (e.g. ORD-2024-000123)
+	structuredIDPattern = regexp.MustCompile(`^[A-Za-z]{2,10}-\d{4}-\d{3,10}$`)
+	// assetHashPattern matches a bundler content hash between the dots of an asset name (e.g. the 4f2a9b in main.4f2a9b.chunk.js)
+	assetHashPattern = regexp.MustCompile(`^[0-9a-f]*[0-9][0-9a-f]*$`)
+	// rangePattern matches numeric ranges (e.g. 100-200)
+	rangePattern = regexp.MustCompile(`^\d+-\d+$`)
+	// apnsTokenPattern matches APNs device tokens (64 hex digits)
+	apnsTokenPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	// fcmTokenPattern matches FCM registration tokens: an optional instance ID, then the APA91 prefixed base64url body
+	fcmTokenPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+:)?APA91[A-Za-z0-9_-]{100,}$`)
+	// objectIDPattern matches MongoDB ObjectIds (24 hex digits)
+	objectIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+	// base32Pattern matches RFC 4648 base32 (upper case A-Z and 2-7) with optional padding
+	base32Pattern = regexp.MustCompile(`^[A-Z2-7]+=*$`)
+	// ibanPattern matches the IBAN structure: country code, check digits and up to 30 alphanumeric characters
+	ibanPattern = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{11,30}$`)
+	// grpcPathPattern matches gRPC method paths: a fully-qualified service and a method (e.g. /pkg.Service/Method)
+	grpcPathPattern = regexp.MustCompile(`^/((?:[A-Za-z_][A-Za-z0-9_]*\.)+[A-Za-z_][A-Za-z0-9_]*)/[A-Za-z_][A-Za-z0-9_]*$`)
+	// geohashPattern matches geohash candidates: 4 to 12 characters of the geohash base32 alphabet (no a, i, l or o)
+	geohashPattern = regexp.MustCompile(`^[0-9b-hjkmnp-z]{4,12}$`)
+	// signaturePattern matches hex or base64 (standard or url-safe) signatures of at least 16 characters
+	signaturePattern = regexp.MustCompile(`^[A-Za-z0-9+_-]{16,}={0,2}$`)
+	// traceIDPattern matches W3C trace context trace IDs (32 lower case hex characters)
+	traceIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	// spanIDPattern matches W3C trace context span (parent) IDs (16 lower case hex characters)
+	spanIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	// mediaSuffixPattern matches a segment with a structured syntax media suffix after its first dot (e.g. users.v2+json)
+	mediaSuffixPattern = regexp.MustCompile(`^([^.]+)\.([A-Za-z0-9.-]+\+[A-Za-z0-9.-]+)$`)
+	// arrayIndexPattern matches array indices, bare or bracketed (e.g. 0 or [0])
+	arrayIndexPattern = regexp.MustCompile(`^(\[\d+\]|\d+)$`)
+	// rawURLBase64Pattern matches unpadded url-safe base64
+	rawURLBase64Pattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// hexGroupsPattern matches 2 to 4 dash separated lower case hex groups of at least 4 characters (e.g. 3f2a-9b1c)
+	hexGroupsPattern = regexp.MustCompile(`^[0-9a-f]{4,}(-[0-9a-f]{4,}){1,3}$`)
+	// fileExtensionPattern matches a plain file extension, starting with a letter (e.g. js, mp4)
+	fileExtensionPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{0,9}$`)
+	// pasetoPattern matches PASETO tokens: version, purpose, payload and optional footer (e.g. v2.local.<payload>)
+	pasetoPattern = regexp.MustCompile(`^v\d\.(local|public)\.[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?$`)
 )
 
+// timezoneNames holds the IANA time zone identifiers recognized as timezone (canonical zones of major cities)
+var timezoneNames = func() map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range strings.Fields(`
+		Africa/Abidjan Africa/Accra Africa/Addis_Ababa Africa/Algiers Africa/Cairo Africa/Casablanca
+		Africa/Dar_es_Salaam Africa/Johannesburg Africa/Khartoum Africa/Kinshasa Africa/Lagos Africa/Luanda
+		Africa/Maputo Africa/Nairobi Africa/Tripoli Africa/Tunis Africa/Windhoek America/Adak
+		America/Anchorage America/Argentina/Buenos_Aires America/Argentina/Cordoba America/Asuncion
+		America/Bogota America/Caracas America/Chicago America/Chihuahua America/Costa_Rica America/Denver
+		America/Edmonton America/El_Salvador America/Guatemala America/Guayaquil America/Halifax
+		America/Havana America/Indiana/Indianapolis America/Kentucky/Louisville America/La_Paz America/Lima
+		America/Los_Angeles America/Managua America/Manaus America/Mexico_City America/Monterrey
+		America/Montevideo America/New_York America/Panama America/Phoenix America/Port_of_Spain
+		America/Puerto_Rico America/Regina America/Santiago America/Santo_Domingo America/Sao_Paulo
+		America/St_Johns America/Tegucigalpa America/Tijuana America/Toronto America/Vancouver
+		America/Winnipeg Antarctica/McMurdo Antarctica/Palmer Asia/Almaty Asia/Amman Asia/Baghdad Asia/Baku
+		Asia/Bangkok Asia/Beirut Asia/Colombo Asia/Damascus Asia/Dhaka Asia/Dubai Asia/Ho_Chi_Minh
+		Asia/Hong_Kong Asia/Irkutsk Asia/Jakarta Asia/Jerusalem Asia/Kabul Asia/Karachi Asia/Kathmandu
+		Asia/Kolkata Asia/Krasnoyarsk Asia/Kuala_Lumpur Asia/Kuwait Asia/Manila Asia/Muscat Asia/Novosibirsk
+		Asia/Qatar Asia/Riyadh Asia/Seoul Asia/Shanghai Asia/Singapore Asia/Taipei Asia/Tashkent
+		Asia/Tbilisi Asia/Tehran Asia/Tokyo Asia/Ulaanbaatar Asia/Vladivostok Asia/Yakutsk Asia/Yangon
+		Asia/Yekaterinburg Asia/Yerevan Atlantic/Azores Atlantic/Bermuda Atlantic/Canary Atlantic/Cape_Verde
+		Atlantic/Reykjavik Australia/Adelaide Australia/Brisbane Australia/Darwin Australia/Hobart
+		Australia/Melbourne Australia/Perth Australia/Sydney Etc/GMT Etc/UTC Europe/Amsterdam Europe/Athens
+		Europe/Belgrade Europe/Berlin Europe/Brussels Europe/Bucharest Europe/Budapest Europe/Copenhagen
+		Europe/Dublin Europe/Helsinki Europe/Istanbul Europe/Kaliningrad Europe/Kyiv Europe/Lisbon
+		Europe/London Europe/Luxembourg Europe/Madrid Europe/Minsk Europe/Moscow Europe/Oslo Europe/Paris
+		Europe/Prague Europe/Riga Europe/Rome Europe/Samara Europe/Sofia Europe/Stockholm Europe/Tallinn
+		Europe/Vienna Europe/Vilnius Europe/Warsaw Europe/Zurich Indian/Maldives Indian/Mauritius
+		Indian/Reunion Pacific/Auckland Pacific/Fiji Pacific/Guam Pacific/Honolulu Pacific/Port_Moresby
+		Pacific/Tongatapu
+	`) {
+		names[name] = true
+	}
+	return names
+}()
+
+// languageCodes holds the ISO 639-1 two-letter language codes
+var languageCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(`
+		aa ab ae af ak am an ar as av ay az ba be bg bi bm bn bo br
+		bs ca ce ch co cr cs cu cv cy da de dv dz ee el en eo es et
+		eu fa ff fi fj fo fr fy ga gd gl gn gu gv ha he hi ho hr ht
+		hu hy hz ia id ie ig ii ik io is it iu ja jv ka kg ki kj kk
+		kl km kn ko kr ks ku kv kw ky la lb lg li ln lo lt lu lv mg
+		mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny
+		oc oj om or os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se
+		sg si sk sl sm sn so sq sr ss st su sv sw ta te tg th ti tk
+		tl tn to tr ts tt tw ty ug uk ur uz ve vi vo wa wo xh yi yo
+		za zh zu
+	`) {
+		codes[code] = true
+	}
+	return codes
+}()
+
 // Config holds the plugin configuration
 type Config struct {
 	HeaderName string `json:"headerName,omitempty"`
+	// BlockThresholdIDs rejects requests whose path contains more than this many detected IDs (0 = never block)
+	BlockThresholdIDs int `json:"blockThresholdIDs,omitempty"`
+	// BlockStatusCode is the status returned for blocked requests, a 4xx or 5xx code
+	BlockStatusCode int `json:"blockStatusCode,omitempty"`
+	// GenericPlaceholder, when set, replaces every detected ID with this value instead of its type label
+	GenericPlaceholder string `json:"genericPlaceholder,omitempty"`
+	// DetectBool labels boolean literals (true/false/yes/no/on/off) as bool
+	DetectBool bool `json:"detectBool,omitempty"`
+	// HandleWellKnown preserves /.well-known/ paths literally, except ACME challenge tokens which become token
+	HandleWellKnown bool `json:"handleWellKnown,omitempty"`
+	// SegmentDelimiter is the separator used to split the path into segments and re-join the group
+	SegmentDelimiter string `json:"segmentDelimiter,omitempty"`
+	// LenientUUID labels segments as uuid when they are 32 hex digits once all dashes are removed
+	LenientUUID bool `json:"lenientUUID,omitempty"`
+	// PreserveFileNames lists exact file names (e.g. index.html) kept literal instead of labeled as file
+	PreserveFileNames []string `json:"preserveFileNames,omitempty"`
+	// UseTrailer also emits the path group as a response trailer once the upstream handler completes
+	UseTrailer bool `json:"useTrailer,omitempty"`
+	// DetectDateParts collapses three consecutive numeric segments forming a MM/DD/YYYY or DD/MM/YYYY date into date
+	DetectDateParts bool `json:"detectDateParts,omitempty"`
+	// KeepFormatSuffix lists extensions (e.g. json, xml) kept on ID segments, so 42.json becomes numeric_id.json
+	KeepFormatSuffix []string `json:"keepFormatSuffix,omitempty"`
+	// SlowLogThresholdMs logs requests whose classification takes longer than this many milliseconds (0 = disabled)
+	SlowLogThresholdMs int `json:"slowLogThresholdMs,omitempty"`
+	// AuditHeaderName, when set, emits the original values of replaced segments on sampled requests
+	AuditHeaderName string `json:"auditHeaderName,omitempty"`
+	// AuditSampleRate emits the audit header on 1 out of every N requests with replaced segments
+	AuditSampleRate int `json:"auditSampleRate,omitempty"`
+	// DetectEmbeddedURL labels percent-encoded segments that decode to an http(s) URL as url
+	DetectEmbeddedURL bool `json:"detectEmbeddedURL,omitempty"`
+	// FailOpen forwards requests without the header when classification fails, instead of responding 500
+	FailOpen bool `json:"failOpen,omitempty"`
+	// TypedPrefixMode labels typed prefixed IDs keeping their prefix, so cus_Nv8f2h8 becomes cus_id
+	TypedPrefixMode bool `json:"typedPrefixMode,omitempty"`
+	// Methods restricts classification to the listed HTTP methods (empty = all methods)
+	Methods []string `json:"methods,omitempty"`
+	// DateAwareFiles labels files whose base name is a date or datetime as date.<ext> instead of file
+	DateAwareFiles bool `json:"dateAwareFiles,omitempty"`
+	// DateFileKeepExtension keeps the extension on date-aware files (date.log), otherwise they become iso_date
+	DateFileKeepExtension bool `json:"dateFileKeepExtension,omitempty"`
+	// OutputFormat selects the header value format: "" (path group) or "datadog" (METHOD /path/{label})
+	OutputFormat string `json:"outputFormat,omitempty"`
+	// LocaleAwareResource labels a leading locale segment (e.g. en-US) as locale
+	LocaleAwareResource bool `json:"localeAwareResource,omitempty"`
+	// DetectEtag labels quoted strong ("...") and weak (W/"...") entity tags as etag
+	DetectEtag bool `json:"detectEtag,omitempty"`
+	// EmptyPathValue is emitted when the path has no segments left to group
+	EmptyPathValue string `json:"emptyPathValue,omitempty"`
+	// DetectIMEI labels 15-digit Luhn-valid numeric segments as imei
+	DetectIMEI bool `json:"detectIMEI,omitempty"`
+	// RedactCardNumbers labels 13-19 digit Luhn-valid numeric segments with RedactionLabel instead of numeric_id
+	RedactCardNumbers bool `json:"redactCardNumbers,omitempty"`
+	// RedactionLabel is the label used for redacted card numbers
+	RedactionLabel string `json:"redactionLabel,omitempty"`
+	// FileClasses maps a class name to file extensions, so matching files are labeled file_<class>
+	FileClasses map[string][]string `json:"fileClasses,omitempty"`
+	// HeadRequestBehavior controls query-less HEAD requests: "classify" (default), "skip" (no header) or "collapse" (/head-probe)
+	HeadRequestBehavior string `json:"headRequestBehavior,omitempty"`
+	// PaginationKeys maps a key segment (e.g. page) to the label used for the numeric value that follows it
+	PaginationKeys map[string]string `json:"paginationKeys,omitempty"`
+	// PrefixLabelMap maps ID prefixes (e.g. user in user_<uuid>) to a type carried into the label (user_uuid)
+	PrefixLabelMap map[string]string `json:"prefixLabelMap,omitempty"`
+	// IncludeFragment normalizes the URL fragment and appends it to the path group after a #
+	IncludeFragment bool `json:"includeFragment,omitempty"`
+	// ActionVerbs lists literal segments (e.g. activate, cancel) tagged as action_<verb> in RPC-style routes
+	ActionVerbs []string `json:"actionVerbs,omitempty"`
+	// Lazy defers classification until a downstream handler calls PathGroupFromContext (programmatic use)
+	Lazy bool `json:"lazy,omitempty"`
+	// ValidateUUIDVariant only labels UUIDs with RFC 4122 variant bits as uuid, others fall through
+	ValidateUUIDVariant bool `json:"validateUUIDVariant,omitempty"`
+	// MaxHeadersPerRequest caps the headers written per request, always keeping the path group header (0 = unlimited)
+	MaxHeadersPerRequest int `json:"maxHeadersPerRequest,omitempty"`
+	// DetectIDLists labels comma- or semicolon-separated lists of IDs as id_list
+	DetectIDLists bool `json:"detectIDLists,omitempty"`
+	// OnGroup is called with the request path and its computed group (programmatic only)
+	OnGroup func(path, group string) `json:"-"`
+	// LangSegmentPosition is the 1-based segment position where an ISO 639-1 language code is labeled lang (0 = disabled), counted after a collapsed leading locale
+	LangSegmentPosition int `json:"langSegmentPosition,omitempty"`
+	// CompositeNumericRun collapses runs of exactly this many consecutive numeric segments into one label (0 = disabled)
+	CompositeNumericRun int `json:"compositeNumericRun,omitempty"`
+	// CompositeNumericLabel is the label used for collapsed numeric runs
+	CompositeNumericLabel string `json:"compositeNumericLabel,omitempty"`
+	// CompressionSuffixes lists precompression extensions stripped before file classification, so app.js.gz becomes file.js
+	CompressionSuffixes []string `json:"compressionSuffixes,omitempty"`
+	// HostOverrides maps a host (exact or leading wildcard like *.example.com) to the configuration used for its requests,
+	// in its raw form so only the fields it sets replace the defaults
+	HostOverrides map[string]map[string]any `json:"hostOverrides,omitempty"`
+	// EntropyThreshold labels otherwise unclassified segments above this Shannon entropy (bits per char) as random (0 = disabled)
+	EntropyThreshold float64 `json:"entropyThreshold,omitempty"`
+	// EntropyMinLength is the minimum segment length for entropy detection
+	EntropyMinLength int `json:"entropyMinLength,omitempty"`
+	// PreservePrefixCase keeps the original casing of mapped prefixes in labels (USR_uuid instead of usr_uuid)
+	PreservePrefixCase bool `json:"preservePrefixCase,omitempty"`
+	// DetectPathDate collapses year/month/day directory segments (e.g. /2024/02/26) forming a valid calendar date into date
+	DetectPathDate bool `json:"detectPathDate,omitempty"`
+	// IncludeOriginalInline emits each replaced segment as label, separator and original value (e.g. uuid|550e8400-...)
+	IncludeOriginalInline bool `json:"includeOriginalInline,omitempty"`
+	// InlineSeparator separates the label from the original value when IncludeOriginalInline is set
+	InlineSeparator string `json:"inlineSeparator,omitempty"`
+	// InlineMaxLength caps the inline path group length; longer groups are emitted with labels only
+	InlineMaxLength int `json:"inlineMaxLength,omitempty"`
+	// FlagTraversal tags requests whose path contains a .. segment with an X-Path-Suspicious: traversal header
+	FlagTraversal bool `json:"flagTraversal,omitempty"`
+	// BlockTraversal rejects paths containing a .. segment with BlockStatusCode
+	BlockTraversal bool `json:"blockTraversal,omitempty"`
+	// DetectCursor labels base64 segments (standard or url-safe, optionally padded) as cursor
+	DetectCursor bool `json:"detectCursor,omitempty"`
+	// DetectPaseto labels PASETO tokens (e.g. v2.local.<payload>) as token
+	DetectPaseto bool `json:"detectPaseto,omitempty"`
+	// OnUnclassified is called with unclassified segments that look like IDs, mixing letters and digits (programmatic only)
+	OnUnclassified func(segment string) `json:"-"`
+	// UnclassifiedSampleRate reports 1 out of every N suspicious unclassified segments (programmatic only)
+	UnclassifiedSampleRate int `json:"-"`
+	// NormalizeDestinationHeader also groups the path of the WebDAV Destination header into X-Destination-Group
+	NormalizeDestinationHeader bool `json:"normalizeDestinationHeader,omitempty"`
+	// DetectStructuredID labels structured IDs of the form PREFIX-YYYY-NNNNNN (e.g. ORD-2024-000123) as structured_id
+	DetectStructuredID bool `json:"detectStructuredID,omitempty"`
+	// StructuredIDPatterns maps a label to a regular expression; matching segments are emitted with that label
+	StructuredIDPatterns map[string]string `json:"structuredIDPatterns,omitempty"`
+	// EncodeOutput percent-encodes reserved characters in literal segments of the path group
+	EncodeOutput bool `json:"encodeOutput,omitempty"`
+	// EncodeCharacters is the set of characters percent-encoded when EncodeOutput is set
+	EncodeCharacters string `json:"encodeCharacters,omitempty"`
+	// DetectTimezone collapses IANA time zone identifiers (e.g. America/New_York) into timezone, including when split across segments
+	DetectTimezone bool `json:"detectTimezone,omitempty"`
+	// GroupPrefixDepth keeps only the first N segments of the group followed by ... (0 = full group)
+	GroupPrefixDepth int `json:"groupPrefixDepth,omitempty"`
+	// DotPrefixExtraction extracts IDs from dotted prefix.ID segments (e.g. t.<uuid>) instead of treating them as file names
+	DotPrefixExtraction bool `json:"dotPrefixExtraction,omitempty"`
+	// KnownRouteTemplates lists the normalized groups (e.g. /users/numeric_id) emitted as is; other groups become UnmatchedValue
+	KnownRouteTemplates []string `json:"knownRouteTemplates,omitempty"`
+	// UnmatchedValue is the group emitted for paths matching none of the known route templates
+	UnmatchedValue string `json:"unmatchedValue,omitempty"`
+	// NormalizeAssetHashes strips content hashes from asset names, so main.4f2a9b.chunk.js becomes main.chunk.js
+	NormalizeAssetHashes bool `json:"normalizeAssetHashes,omitempty"`
+	// OpaqueAboveSegments emits OpaqueValue without classifying paths with more than this many segments (0 = disabled)
+	OpaqueAboveSegments int `json:"opaqueAboveSegments,omitempty"`
+	// OpaqueValue is the group emitted for paths above OpaqueAboveSegments
+	OpaqueValue string `json:"opaqueValue,omitempty"`
+	// DetectGitRefs collapses the branch or tag name after refs/heads or refs/tags, including nested names, into ref
+	DetectGitRefs bool `json:"detectGitRefs,omitempty"`
+	// UppercaseBehavior controls unlabeled ALL-CAPS segments: preserve them, label them as code, or lowercase them
+	UppercaseBehavior string `json:"uppercaseBehavior,omitempty"`
+	// SourceHeaders lists request headers (e.g. X-Original-URL) tried in order for the URL to group, before the request path
+	SourceHeaders []string `json:"sourceHeaders,omitempty"`
+	// DetectRange labels numeric ranges (e.g. 100-200) as range instead of slug
+	DetectRange bool `json:"detectRange,omitempty"`
+	// CanonicalizeInsteadOfLabel lists detector labels (e.g. uuid) whose segments are emitted in canonical form instead of labeled
+	CanonicalizeInsteadOfLabel []string `json:"canonicalizeInsteadOfLabel,omitempty"`
+	// DetectDeviceToken labels APNs (64 hex digits) and FCM push tokens as device_token
+	DetectDeviceToken bool `json:"detectDeviceToken,omitempty"`
+	// QueryStatsHeaderName, when set, emits the number of query parameters of the request (e.g. params=3)
+	QueryStatsHeaderName string `json:"queryStatsHeaderName,omitempty"`
+	// ObjectIDParents lists the collection segments (e.g. orders) after which a 24-hex segment is labeled objectid
+	ObjectIDParents []string `json:"objectIDParents,omitempty"`
+	// EmptySegmentBehavior controls empty segments from double slashes: drop them, preserve them as empty, or reject the request
+	EmptySegmentBehavior string `json:"emptySegmentBehavior,omitempty"`
+	// DetectBase32 labels upper case RFC 4648 base32 segments (e.g. TOTP secrets) as base32
+	DetectBase32 bool `json:"detectBase32,omitempty"`
+	// LearnSink is called once with each distinct group seen, to bootstrap known route templates (programmatic only)
+	LearnSink func(group string) `json:"-"`
+	// LearnSampleRate considers 1 out of every N requests for LearnSink (programmatic only)
+	LearnSampleRate int `json:"-"`
+	// RouteSchemas declare the expected label of each segment after a route prefix; mismatches are flagged in X-Path-Schema-Mismatch
+	RouteSchemas []RouteSchema `json:"routeSchemas,omitempty"`
+	// DetectIBAN labels IBANs passing the mod-97 checksum as iban
+	DetectIBAN bool `json:"detectIBAN,omitempty"`
+	// CompoundDelimiters splits segments on these delimiters (e.g. |) and classifies each part, so 42|abc-1 becomes numeric_id|slug
+	CompoundDelimiters []string `json:"compoundDelimiters,omitempty"`
+	// CompoundMinFraction is the fraction of parts that must be classified for a compound segment to be split (default all)
+	CompoundMinFraction float64 `json:"compoundMinFraction,omitempty"`
+	// SelfAliases lists self-referential segments (e.g. me, self, current) labeled SelfLabel, grouping /users/me with /users/42
+	SelfAliases []string `json:"selfAliases,omitempty"`
+	// SelfLabel is the label emitted for self aliases, matching the ID label of their sibling routes
+	SelfLabel string `json:"selfLabel,omitempty"`
+	// GRPCServiceHeaderName, when set, keeps gRPC method paths (/pkg.Service/Method) unchanged and emits their service in this header
+	GRPCServiceHeaderName string `json:"grpcServiceHeaderName,omitempty"`
+	// ReservedSubresources lists sub-resource names (e.g. avatar, items) that are never labeled by any detector
+	ReservedSubresources []string `json:"reservedSubresources,omitempty"`
+	// GenericizeIDs emits id for every identifier scheme label (uuid, numeric_id, ulid, ...), keeping structural labels like file;
+	// prefix-mapped labels keep their type (e.g. user_uuid -> user_id)
+	GenericizeIDs bool `json:"genericizeIDs,omitempty"`
+	// DetectGeohash labels lower case geohashes (e.g. u4pruydqqvj) as geohash
+	DetectGeohash bool `json:"detectGeohash,omitempty"`
+	// BaggageKey, when set, propagates the group in the W3C baggage header under this key (e.g. route=%2Fusers%2Fuuid)
+	BaggageKey string `json:"baggageKey,omitempty"`
+	// RiskHeaderName, when set, emits a cardinality risk score: the number of literal segments past the first two positions
+	RiskHeaderName string `json:"riskHeaderName,omitempty"`
+	// AggregateSuffixes lists trailing literals (e.g. count, sum, stats) tagged with AggregateLabel, so /posts/count becomes /posts/aggregate_count
+	AggregateSuffixes []string `json:"aggregateSuffixes,omitempty"`
+	// AggregateLabel is the tag prepended to aggregate suffixes
+	AggregateLabel string `json:"aggregateLabel,omitempty"`
+	// LegacyStarMode restricts detection to UUIDs, numbers and slugs with a digit and a separator, all emitted as *
+	LegacyStarMode bool `json:"legacyStarMode,omitempty"`
+	// DetectOAuthState labels the segment following an OAuth state key (e.g. /state/<value>) as token
+	DetectOAuthState bool `json:"detectOAuthState,omitempty"`
+	// OAuthStateKeys lists the key segments whose following value is an OAuth state or nonce
+	OAuthStateKeys []string `json:"oauthStateKeys,omitempty"`
+	// RouteTemplates lists route templates (e.g. /api/v1/users/{id}) matched instead of per-segment detection;
+	// the matched template is emitted as is and unmatched paths become UnmatchedValue
+	RouteTemplates []string `json:"routeTemplates,omitempty"`
+	// CollapseNonASCII labels segments containing any non-ASCII character (e.g. emoji or CJK slugs) as unicode
+	CollapseNonASCII bool `json:"collapseNonASCII,omitempty"`
+	// FullTargetHeaderName, when set, emits the group prefixed with the request scheme and normalized host (e.g. https://api.example.com/users/uuid)
+	FullTargetHeaderName string `json:"fullTargetHeaderName,omitempty"`
+	// SignatureSuffixKeys lists key segments (e.g. sig, signature) whose following hex or base64 value is labeled signature
+	SignatureSuffixKeys []string `json:"signatureSuffixKeys,omitempty"`
+	// MaxDistinctGroups, when positive, bounds the distinct groups emitted; later new groups collapse to /overflow
+	// and a warning is logged the first time the cap is hit
+	MaxDistinctGroups int `json:"maxDistinctGroups,omitempty"`
+	// DetectTraceIDs labels W3C trace IDs (32 hex) as trace_id and span IDs (16 hex) as span_id, ahead of dashless UUIDs
+	DetectTraceIDs bool `json:"detectTraceIDs,omitempty"`
+	// ExampleSink is called with one original value per detected label, at most once per label per ExampleWindow (programmatic only)
+	ExampleSink func(label, original string) `json:"-"`
+	// ExampleWindow is how long a label waits before ExampleSink receives another example for it (programmatic only, 0 = once per label)
+	ExampleWindow time.Duration `json:"-"`
+	// DetectMediaSuffix splits content negotiation suffixes (e.g. users.v2+json -> users.media_type), classifying the base
+	DetectMediaSuffix bool `json:"detectMediaSuffix,omitempty"`
+	// DetectArrayIndex labels array index segments, bare or bracketed (e.g. /items/[0]/value), as index instead of numeric_id
+	DetectArrayIndex bool `json:"detectArrayIndex,omitempty"`
+	// ContentTypeGroups maps request content type prefixes (e.g. multipart/form-data) to a segment appended to the group
+	// (e.g. upload); the longest matching prefix wins
+	ContentTypeGroups map[string]string `json:"contentTypeGroups,omitempty"`
+	// DetectPlaceholders labels synthetic test IDs as placeholder: the nil UUID, repeated digits (e.g. 000, 1111)
+	// and sequential digits (e.g. 123456789)
+	DetectPlaceholders bool `json:"detectPlaceholders,omitempty"`
+	// IncludeMiddlewareName sets X-Path-Group-By to the middleware name, to tell chained instances apart
+	IncludeMiddlewareName bool `json:"includeMiddlewareName,omitempty"`
+	// CollapseTraversalAfter, when positive, keeps the first N literal/ID pairs of a path alternating literals and IDs
+	// (e.g. graph traversals like /v/42/e/57/v/88) and replaces the rest of the run with traversal
+	CollapseTraversalAfter int `json:"collapseTraversalAfter,omitempty"`
+	// CursorLengths lists the exact lengths of unpadded url-safe base64 cursors (e.g. 27, 43) labeled cursor
+	CursorLengths []int `json:"cursorLengths,omitempty"`
+	// PrefixHeaders maps path prefixes (e.g. /api/) to the header set instead of HeaderName; the longest matching prefix wins
+	PrefixHeaders map[string]string `json:"prefixHeaders,omitempty"`
+	// DetectHexGroups labels 2 to 4 dash separated hex groups mixing letters and digits (e.g. 3f2a-9b1c shard keys) as hex_key
+	DetectHexGroups bool `json:"detectHexGroups,omitempty"`
+	// PathMetricsHeaders emits the request path byte length in X-Path-Length and its segment count in X-Path-Depth
+	PathMetricsHeaders bool `json:"pathMetricsHeaders,omitempty"`
+	// Logger receives slow-classification logs (programmatic only, defaults to the standard logger)
+	Logger Logger `json:"-"`
+}
+
+// RouteSchema declares the expected segment types of the routes under a prefix
+type RouteSchema struct {
+	// Prefix is the literal route prefix the schema applies to (e.g. /api/v1/users)
+	Prefix string `json:"prefix,omitempty"`
+	// Labels lists the expected label of each segment after the prefix (literal for an unlabeled segment, empty for any)
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Logger is the logging interface used by the middleware, satisfied by *log.Logger
+type Logger interface {
+	Printf(format string, v ...any)
 }
 
 // CreateConfig returns the default plugin configuration
 func CreateConfig() *Config {
 	return &Config{
-		HeaderName: defaultHeaderName,
+		HeaderName:            defaultHeaderName,
+		BlockStatusCode:       defaultBlockStatusCode,
+		HandleWellKnown:       true,
+		SegmentDelimiter:      defaultDelimiter,
+		AuditSampleRate:       defaultAuditSampleRate,
+		FailOpen:              true,
+		DateFileKeepExtension: true,
+		RedactCardNumbers:     true,
+		RedactionLabel:        defaultRedactionLabel,
+		HeadRequestBehavior:   headBehaviorClassify,
+		CompositeNumericLabel: defaultCompositeLabel,
+		CompressionSuffixes:   []string{"gz", "br", "zst"},
+		EntropyMinLength:      defaultEntropyMinLen,
+		InlineSeparator:       defaultInlineSeparator,
+		InlineMaxLength:       defaultInlineMaxLength,
+		EncodeCharacters:      defaultEncodeChars,
+		UnmatchedValue:        defaultUnmatchedValue,
+		OpaqueValue:           defaultOpaqueValue,
+		UppercaseBehavior:     uppercasePreserve,
+		EmptySegmentBehavior:  emptySegmentDrop,
+		CompoundMinFraction:   1,
+		SelfLabel:             defaultSelfLabel,
+		AggregateLabel:        defaultAggregateLabel,
+		OAuthStateKeys:        []string{"state", "nonce", "code"},
 	}
 }
 
-// AddPathHeader is the middleware plugin that injects the request path into a header
-type AddPathHeader struct {
-	next       http.Handler
-	headerName string
-	name       string
-}
+// ParseConfig decodes a JSON configuration over the defaults, rejecting unknown fields so typos
+// (e.g. headerNam) fail instead of silently keeping a default. Traefik decodes the configuration
+// itself, so this is meant for programmatic use and for checking configuration files.
+func ParseConfig(data []byte) (*Config, error) {
+	config := CreateConfig()
 
-// New creates a new AddPathHeader middleware plugin instance.
-func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	headerName := config.HeaderName
-	if headerName == "" {
-		headerName = defaultHeaderName
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	return config, nil
+}
 
-	return &AddPathHeader{
-		next:       next,
-		headerName: headerName,
-		name:       name,
-	}, nil
+// DetectorInfo describes a segment detector and the label it emits for a configuration
+type DetectorInfo struct {
+	Name    string
+	Label   string
+	Enabled bool
 }
 
-// identifyIDType identifies the type of ID in a segment, checking patterns in order of specificity.
-// Returns the ID type label if matched, empty string otherwise.
-// Also handles prefixed IDs (e.g., "prefix:uuid", "prefix_nanoid").
-func identifyIDType(segment string) string {
-	if segment == "" {
-		return ""
+// ActiveDetectors lists the segment detectors in classification order, with the label each one emits
+// and whether the configuration enables it
+func (c *Config) ActiveDetectors() []DetectorInfo {
+	redactionLabel := c.RedactionLabel
+	if redactionLabel == "" {
+		redactionLabel = defaultRedactionLabel
 	}
 
-	// 1. Check UUID (unique dash structure, 36 chars)
-	if uuidPattern.MatchString(segment) {
-		return labelUUID
+	compositeLabel := c.CompositeNumericLabel
+	if compositeLabel == "" {
+		compositeLabel = defaultCompositeLabel
 	}
 
-	// 2. Check Numeric (digits only, unambiguous)
-	if numericPattern.MatchString(segment) {
-		return labelNumericID
+	selfLabel := c.SelfLabel
+	if selfLabel == "" {
+		selfLabel = defaultSelfLabel
 	}
 
-	// 3. Check ISO Date/Datetime (YYYY-MM-DD with optional time and timezone)
-	if isoDatePattern.MatchString(segment) {
-		return labelISODate
+	aggregateLabel := c.AggregateLabel
+	if aggregateLabel == "" {
+		aggregateLabel = defaultAggregateLabel
 	}
 
-	// 4. Check ULID (26 chars, specific charset)
-	if ulidPattern.MatchString(segment) {
-		return labelULID
+	dateFileLabel := labelISODate
+	if c.DateFileKeepExtension {
+		dateFileLabel = labelDate + ".<ext>"
 	}
 
-	// 5. Check CUID (25 chars, starts with 'c')
-	if cuidPattern.MatchString(segment) {
-		return labelCUID
+	detectors := []DetectorInfo{
+		{Name: "empty", Label: labelEmpty, Enabled: c.EmptySegmentBehavior == emptySegmentPreserve},
+		{Name: "acme_challenge", Label: labelToken, Enabled: c.HandleWellKnown},
+		{Name: "self_alias", Label: selfLabel, Enabled: len(c.SelfAliases) > 0},
+		{Name: "non_ascii", Label: labelUnicode, Enabled: c.CollapseNonASCII},
+		{Name: "locale", Label: labelLocale, Enabled: c.LocaleAwareResource},
+		{Name: "lang", Label: labelLang, Enabled: c.LangSegmentPosition > 0},
+		{Name: "date_parts", Label: labelDate, Enabled: c.DetectDateParts},
+		{Name: "path_date", Label: labelDate, Enabled: c.DetectPathDate},
+		{Name: "timezone", Label: labelTimezone, Enabled: c.DetectTimezone},
+		{Name: "git_ref", Label: labelRef, Enabled: c.DetectGitRefs},
+		{Name: "composite_numeric", Label: compositeLabel, Enabled: c.CompositeNumericRun > 0},
+		{Name: "traversal_run", Label: labelTraversal, Enabled: c.CollapseTraversalAfter > 0},
 	}
 
-	// 6. Check CUID2 (24 chars, starts with lowercase)
-	if cuid2Pattern.MatchString(segment) {
-		return labelCUID2
+	// Pagination keys are checked first among the keyed values, one entry per key
+	paginationKeys := make([]string, 0, len(c.PaginationKeys))
+	for key := range c.PaginationKeys {
+		paginationKeys = append(paginationKeys, key)
+	}
+	sort.Strings(paginationKeys)
+	for _, key := range paginationKeys {
+		detectors = append(detectors, DetectorInfo{Name: paginationDetectorPrefix + key, Label: c.PaginationKeys[key], Enabled: true})
 	}
 
-	// 7. Check NanoID (21 chars, broader charset, must contain a digit)
-	if len(segment) == 21 && nanoidPattern.MatchString(segment) {
-		return labelNanoID
+	detectors = append(detectors, []DetectorInfo{
+		{Name: "signature", Label: labelSignature, Enabled: len(c.SignatureSuffixKeys) > 0},
+		{Name: "oauth_state", Label: labelToken, Enabled: c.DetectOAuthState && len(c.OAuthStateKeys) > 0},
+		{Name: "embedded_url", Label: labelURL, Enabled: c.DetectEmbeddedURL},
+		{Name: "etag", Label: labelEtag, Enabled: c.DetectEtag},
+		{Name: "bool", Label: labelBool, Enabled: c.DetectBool},
+		{Name: "paseto", Label: labelToken, Enabled: c.DetectPaseto},
+	}...)
+
+	// Configured structured ID patterns are checked in label order, before the built-in ones
+	patternLabels := make([]string, 0, len(c.StructuredIDPatterns))
+	for label := range c.StructuredIDPatterns {
+		patternLabels = append(patternLabels, label)
+	}
+	sort.Strings(patternLabels)
+	for _, label := range patternLabels {
+		detectors = append(detectors, DetectorInfo{Name: structuredPatternDetectorPrefix + label, Label: label, Enabled: true})
 	}
 
-	// 8. Check File (segments ending with file extension like .html, .css, .js, .png)
-	if filePattern.MatchString(segment) {
-		return labelFile
+	detectors = append(detectors, []DetectorInfo{
+		{Name: "structured_id", Label: labelStructID, Enabled: c.DetectStructuredID},
+		{Name: "device_token", Label: labelDevice, Enabled: c.DetectDeviceToken},
+		{Name: "iban", Label: labelIBAN, Enabled: c.DetectIBAN},
+		{Name: "placeholder", Label: labelPlaceholder, Enabled: c.DetectPlaceholders},
+		{Name: "uuid", Label: labelUUID, Enabled: true},
+		{Name: "trace_id", Label: labelTraceID, Enabled: c.DetectTraceIDs},
+		{Name: "span_id", Label: labelSpanID, Enabled: c.DetectTraceIDs},
+		{Name: "lenient_uuid", Label: labelUUID, Enabled: c.LenientUUID},
+		{Name: "array_index", Label: labelIndex, Enabled: c.DetectArrayIndex},
+		{Name: "imei", Label: labelIMEI, Enabled: c.DetectIMEI},
+		{Name: "card_number", Label: redactionLabel, Enabled: c.RedactCardNumbers},
+		{Name: "numeric_id", Label: labelNumericID, Enabled: true},
+		{Name: "iso_date", Label: labelISODate, Enabled: true},
+		{Name: "ulid", Label: labelULID, Enabled: true},
+		{Name: "cuid", Label: labelCUID, Enabled: true},
+		{Name: "cuid2", Label: labelCUID2, Enabled: true},
+		{Name: "nanoid", Label: labelNanoID, Enabled: true},
+		{Name: "cursor_length", Label: labelCursor, Enabled: len(c.CursorLengths) > 0},
+		{Name: "base32", Label: labelBase32, Enabled: c.DetectBase32},
+		{Name: "geohash", Label: labelGeohash, Enabled: c.DetectGeohash},
+		{Name: "media_suffix", Label: "<base>." + labelMedia, Enabled: c.DetectMediaSuffix},
+		{Name: "format_suffix", Label: "<label>.<ext>", Enabled: len(c.KeepFormatSuffix) > 0},
+		{Name: compoundDetector, Label: "<parts>", Enabled: len(c.CompoundDelimiters) > 0},
+		{Name: "id_list", Label: labelIDList, Enabled: c.DetectIDLists},
+		{Name: "compression_suffix", Label: labelFile + ".<ext>", Enabled: len(c.CompressionSuffixes) > 0},
+		{Name: "dot_prefix", Label: "<label>", Enabled: c.DotPrefixExtraction},
+		{Name: "date_file", Label: dateFileLabel, Enabled: c.DateAwareFiles},
+	}...)
+
+	// File classes are checked before the plain file label, one entry per class
+	fileClasses := make([]string, 0, len(c.FileClasses))
+	for class := range c.FileClasses {
+		fileClasses = append(fileClasses, class)
+	}
+	sort.Strings(fileClasses)
+	for _, class := range fileClasses {
+		detectors = append(detectors, DetectorInfo{Name: fileClassDetectorPrefix + class, Label: labelFile + "_" + class, Enabled: true})
 	}
 
-	// 9. Try prefix extraction (check for prefix:ID or prefix_ID)
-	// Try colon separator first (unambiguous)
-	if idx := strings.Index(segment, ":"); idx > 0 {
-		prefix := segment[:idx]
-		suffix := segment[idx+1:]
-		if suffix != "" && (prefixPattern.MatchString(prefix) || identifyIDType(prefix) != "") {
-			if label := identifyIDType(suffix); label != "" {
-				return label
-			}
+	detectors = append(detectors, []DetectorInfo{
+		{Name: "file", Label: labelFile, Enabled: true},
+		{Name: "typed_prefix", Label: "<prefix>" + typedIDSuffix, Enabled: c.TypedPrefixMode},
+	}...)
+
+	// Mapped prefixes carry their type into the label of the extracted ID, one entry per prefix
+	prefixes := make([]string, 0, len(c.PrefixLabelMap))
+	for prefix := range c.PrefixLabelMap {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		label := strings.ToLower(c.PrefixLabelMap[prefix]) + "_<label>"
+		if c.PreservePrefixCase {
+			label = "<prefix>_<label>"
 		}
+		detectors = append(detectors, DetectorInfo{Name: prefixLabelDetectorPrefix + strings.ToLower(prefix), Label: label, Enabled: true})
 	}
 
-	// Try underscore separator (can appear in NanoID, but we already checked full segment)
-	// For underscore, treat as prefixed ID if:
-	// - Suffix matches non-numeric ID patterns (UUID, ULID, CUID, CUID2, NanoID, ISO Date), OR
-	// - Suffix is numeric with 3+ digits (longer numeric IDs are more likely to be prefixed)
-	// Shorter numeric suffixes (1-2 digits) are more likely to be slugs like "user_42"
-	if idx := strings.Index(segment, "_"); idx > 0 {
-		prefix := segment[:idx]
-		suffix := segment[idx+1:]
-		if prefixPattern.MatchString(prefix) && suffix != "" {
-			// Check if suffix matches a non-numeric ID pattern
-			if uuidPattern.MatchString(suffix) ||
-				isoDatePattern.MatchString(suffix) ||
-				ulidPattern.MatchString(suffix) ||
-				cuidPattern.MatchString(suffix) ||
-				cuid2Pattern.MatchString(suffix) ||
-				(len(suffix) == 21 && nanoidPattern.MatchString(suffix)) {
-				// Recursively identify the ID type
-				if label := identifyIDType(suffix); label != "" {
-					return label
-				}
-			} else if numericPattern.MatchString(suffix) && len(suffix) >= 3 {
-				// Numeric suffix with 3+ digits - treat as prefixed numeric ID
-				return labelNumericID
+	detectors = append(detectors, []DetectorInfo{
+		{Name: "cursor", Label: labelCursor, Enabled: c.DetectCursor},
+		{Name: "range", Label: labelRange, Enabled: c.DetectRange},
+		{Name: "hex_groups", Label: labelHexKey, Enabled: c.DetectHexGroups},
+		{Name: "slug", Label: labelSlug, Enabled: true},
+		{Name: "random", Label: labelRandom, Enabled: c.EntropyThreshold > 0},
+		{Name: "uppercase", Label: labelCode, Enabled: c.UppercaseBehavior == uppercaseLabel},
+	}...)
+
+	// Aggregate suffixes and action verbs tag the literals left unlabeled, one entry per tagged literal
+	aggregateSuffixes := append([]string(nil), c.AggregateSuffixes...)
+	sort.Strings(aggregateSuffixes)
+	for _, suffix := range aggregateSuffixes {
+		detectors = append(detectors, DetectorInfo{Name: aggregateDetectorPrefix + suffix, Label: aggregateLabel + "_" + suffix, Enabled: true})
+	}
+
+	actionVerbs := append([]string(nil), c.ActionVerbs...)
+	sort.Strings(actionVerbs)
+	for _, verb := range actionVerbs {
+		detectors = append(detectors, DetectorInfo{Name: actionDetectorPrefix + verb, Label: labelAction + "_" + verb, Enabled: true})
+	}
+
+	// Legacy star mode replaces the single-segment detectors, only UUIDs, numbers and slugs are labeled
+	if c.LegacyStarMode {
+		for i, detector := range detectors {
+			switch {
+			case crossSegmentDetectors[detector.Name], strings.HasPrefix(detector.Name, paginationDetectorPrefix), isTagDetector(detector.Name):
+			case legacyStarDetectors[detector.Name]:
+				detectors[i].Label = legacyStarLabel
+				detectors[i].Enabled = true
+			default:
+				detectors[i].Enabled = false
 			}
 		}
 	}
 
-	// 10. Check slug (alphanumeric with digits and separators)
-	if slugPattern.MatchString(segment) {
-		hasDigit := false
-		hasLetter := false
-		hasSeparator := false
-		for _, r := range segment {
-			if r >= '0' && r <= '9' {
-				hasDigit = true
-			}
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
-				hasLetter = true
+	if c.GenericizeIDs {
+		for i, detector := range detectors {
+			if generic, ok := genericLabel(detector.Label); ok {
+				detectors[i].Label = generic
 			}
-			if r == '-' || r == '_' {
-				hasSeparator = true
+		}
+	}
+
+	// The generic placeholder replaces every label in the output, tags and the empty segment label are not replaced
+	if c.GenericPlaceholder != "" {
+		for i, detector := range detectors {
+			if detector.Name != labelEmpty && !isTagDetector(detector.Name) {
+				detectors[i].Label = c.GenericPlaceholder
 			}
 		}
-		if hasDigit && hasSeparator {
-			return labelSlug
+	}
+
+	return detectors
+}
+
+// ActiveDetectors entries, for the detectors whose entries depend on the configuration
+const (
+	paginationDetectorPrefix        = "pagination_"
+	structuredPatternDetectorPrefix = "structured_pattern_"
+	fileClassDetectorPrefix         = "file_class_"
+	prefixLabelDetectorPrefix       = "prefix_label_"
+	aggregateDetectorPrefix         = "aggregate_"
+	actionDetectorPrefix            = "action_"
+	compoundDetector                = "compound"
+)
+
+// isTagDetector reports whether an ActiveDetectors entry tags a literal left unlabeled (e.g. action_cancel)
+func isTagDetector(name string) bool {
+	return strings.HasPrefix(name, aggregateDetectorPrefix) || strings.HasPrefix(name, actionDetectorPrefix)
+}
+
+// literalDetector is the CardinalityProfile entry for segments no detector labels, which are kept as is
+const literalDetector = "literal"
+
+// CardinalityProfile reports, for each enabled detector, whether its output is bounded: true when it always
+// emits the same label, false when the output keeps part of the original segment (e.g. typed prefixes).
+// The literal fallback is always present and never bounded.
+func (c *Config) CardinalityProfile() map[string]bool {
+	canonicalize := make(map[string]bool, len(c.CanonicalizeInsteadOfLabel))
+	for _, label := range c.CanonicalizeInsteadOfLabel {
+		canonicalize[label] = true
+	}
+
+	// Segments are canonicalized on the label the detector emits, before it is genericized or replaced
+	raw := *c
+	raw.GenericizeIDs = false
+	raw.GenericPlaceholder = ""
+	rawDetectors := raw.ActiveDetectors()
+
+	profile := map[string]bool{literalDetector: false}
+	for i, detector := range c.ActiveDetectors() {
+		if !detector.Enabled {
+			continue
 		}
-		// Also match purely alphanumeric segments (no separators) that mix letters and digits
-		// and are at least 8 characters long (avoids false positives with short version prefixes)
-		if hasDigit && hasLetter && !hasSeparator && len(segment) >= 8 {
-			return labelSlug
+		switch {
+		case canonicalize[rawDetectors[i].Label]:
+			// Canonical IDs keep their identity
+			profile[detector.Name] = false
+		case c.IncludeOriginalInline && !(detector.Name == "card_number" && c.RedactCardNumbers) &&
+			detector.Name != labelEmpty && !isTagDetector(detector.Name):
+			// Inline originals follow every label except redacted card numbers, tags are literals already
+			profile[detector.Name] = false
+		default:
+			// Variable parts of a label are written as <part>
+			profile[detector.Name] = !strings.Contains(detector.Label, "<")
 		}
 	}
 
-	return ""
+	// Compound parts are labels only when all of them must be classified, otherwise literal parts are kept
+	if _, ok := profile[compoundDetector]; ok && !c.IncludeOriginalInline {
+		profile[compoundDetector] = c.CompoundMinFraction <= 0 || c.CompoundMinFraction >= 1
+	}
+	return profile
 }
 
-// extractPathGroup normalizes a path by replacing ID segments with their type labels
-func extractPathGroup(path string) string {
-	if path == "" || path == "/" {
-		return path
-	}
+// AddPathHeader is the middleware plugin that injects the request path into a header
+type AddPathHeader struct {
+	next                http.Handler
+	headerName          string
+	name                string
+	blockThresholdIDs   int
+	blockStatusCode     int
+	placeholder         string
+	detectBool          bool
+	handleWellKnown     bool
+	delimiter           string
+	lenientUUID         bool
+	preservedFiles      map[string]bool
+	useTrailer          bool
+	detectDateParts     bool
+	formatSuffixes      map[string]bool
+	slowLogThreshold    time.Duration
+	logger              Logger
+	now                 func() time.Time
+	auditHeaderName     string
+	auditSampleRate     uint64
+	auditRequests       atomic.Uint64
+	detectEmbeddedURL   bool
+	failOpen            bool
+	typedPrefixMode     bool
+	methods             map[string]bool
+	dateAwareFiles      bool
+	dateFileKeepExt     bool
+	outputFormat        string
+	localeAware         bool
+	detectEtag          bool
+	emptyPathValue      string
+	detectIMEI          bool
+	redactCardNumbers   bool
+	redactionLabel      string
+	fileClasses         map[string]string
+	headBehavior        string
+	paginationKeys      map[string]string
+	prefixLabels        map[string]string
+	includeFragment     bool
+	actionVerbs         map[string]bool
+	lazy                bool
+	validateUUIDVariant bool
+	maxHeaders          int
+	detectIDLists       bool
+	onGroup             func(path, group string)
+	langPosition        int
+	compositeRun        int
+	compositeLabel      string
+	compressionSuffixes map[string]bool
+	exactHosts          map[string]*AddPathHeader
+	wildcardHosts       []hostOverride
+	entropyThreshold    float64
+	entropyMinLength    int
+	preservePrefixCase  bool
+	detectPathDate      bool
+	includeInline       bool
+	inlineSeparator     string
+	inlineMaxLength     int
+	flagTraversal       bool
+	blockTraversal      bool
+	detectCursor        bool
+	detectPaseto        bool
+	onUnclassified      func(segment string)
+	unclassifiedRate    uint64
+	unclassifiedSeen    atomic.Uint64
+	normalizeDest       bool
+	detectStructuredID  bool
+	structuredPatterns  []structuredPattern
+	encodeOutput        bool
+	encodeChars         string
+	detectTimezone      bool
+	prefixDepth         int
+	dotPrefix           bool
+	knownRoutes         map[string]bool
+	unmatchedValue      string
+	normalizeAssets     bool
+	opaqueAbove         int
+	opaqueValue         string
+	detectGitRefs       bool
+	uppercaseBehavior   string
+	sourceHeaders       []string
+	detectRange         bool
+	canonicalize        map[string]bool
+	detectDeviceToken   bool
+	queryStatsHeader    string
+	objectIDParents     map[string]bool
+	emptySegments       string
+	detectBase32        bool
+	learnSink           func(group string)
+	learnRate           uint64
+	learnRequests       atomic.Uint64
+	learnMu             sync.Mutex
+	learned             map[string]bool
+	routeSchemas        []routeSchema
+	detectIBAN          bool
+	compoundDelimiters  []string
+	compoundMinFraction float64
+	selfAliases         map[string]bool
+	selfLabel           string
+	grpcServiceHeader   string
+	reserved            map[string]bool
+	genericizeIDs       bool
+	detectGeohash       bool
+	baggageKey          string
+	riskHeader          string
+	aggregateSuffixes   map[string]bool
+	aggregateLabel      string
+	oauthStateKeys      map[string]bool
+	routeTrie           *routeNode
+	collapseNonASCII    bool
+	fullTargetHeader    string
+	signatureKeys       map[string]bool
+	maxDistinctGroups   int
+	distinctMu          sync.Mutex
+	distinct            map[string]bool
+	overflowWarned      bool
+	detectTraceIDs      bool
+	exampleSink         func(label, original string)
+	exampleWindow       time.Duration
+	exampleMu           sync.Mutex
+	exampleSent         map[string]time.Time
+	detectMediaSuffix   bool
+	detectArrayIndex    bool
+	contentTypeGroups   []prefixValue
+	detectPlaceholders  bool
+	includeName         bool
+	traversalAfter      int
+	cursorLengths       map[int]bool
+	prefixHeaders       []prefixValue
+	detectHexGroups     bool
+	pathMetrics         bool
+	// classify identifies the ID type of a single segment
+	classify func(segment string) string
+}
 
-	segments := strings.Split(strings.Trim(path, "/"), "/")
-	result := make([]string, 0, len(segments))
+// New creates a new AddPathHeader middleware plugin instance.
+func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	a, err := newAddPathHeader(next, config, name)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, segment := range segments {
-		if segment == "" {
+	// Each host override is a configuration of its own, with unset fields taking their default values
+	for host, override := range config.HostOverrides {
+		if override == nil {
 			continue
 		}
+		merged, err := hostConfig(config, override)
+		if err != nil {
+			return nil, fmt.Errorf("host override %q: %w", host, err)
+		}
+		hostHandler, err := newAddPathHeader(next, merged, name)
+		if err != nil {
+			return nil, fmt.Errorf("host override %q: %w", host, err)
+		}
 
-		if label := identifyIDType(segment); label != "" {
-			result = append(result, label)
+		host = strings.ToLower(host)
+		if strings.HasPrefix(host, "*.") {
+			a.wildcardHosts = append(a.wildcardHosts, hostOverride{suffix: host[1:], handler: hostHandler})
 		} else {
-			result = append(result, segment)
+			a.exactHosts[host] = hostHandler
 		}
 	}
 
-	return "/" + strings.Join(result, "/")
+	// Longest suffixes first so the most specific wildcard wins
+	sort.Slice(a.wildcardHosts, func(i, j int) bool {
+		return len(a.wildcardHosts[i].suffix) > len(a.wildcardHosts[j].suffix)
+	})
+
+	return a, nil
 }
 
-func (a *AddPathHeader) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	pathGroup := extractPathGroup(req.URL.Path)
-	req.Header.Set(a.headerName, pathGroup)
-	a.next.ServeHTTP(rw, req)
+// hostConfig decodes a raw host override over the defaults, so explicitly set values (including false)
+// replace them and unset fields keep them. Unknown fields are rejected. The programmatic options
+// cannot be set in an override and are taken from the base configuration.
+func hostConfig(base *Config, override map[string]any) (*Config, error) {
+	data, err := json.Marshal(override)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	merged.OnGroup = base.OnGroup
+	merged.OnUnclassified = base.OnUnclassified
+	merged.UnclassifiedSampleRate = base.UnclassifiedSampleRate
+	merged.LearnSink = base.LearnSink
+	merged.LearnSampleRate = base.LearnSampleRate
+	merged.ExampleSink = base.ExampleSink
+	merged.ExampleWindow = base.ExampleWindow
+	merged.Logger = base.Logger
+	return merged, nil
+}
+
+// newAddPathHeader creates a middleware instance for a single configuration, ignoring host overrides
+func newAddPathHeader(next http.Handler, config *Config, name string) (*AddPathHeader, error) {
+	headerName := config.HeaderName
+	if headerName == "" {
+		headerName = defaultHeaderName
+	}
+
+	blockStatusCode := config.BlockStatusCode
+	if blockStatusCode == 0 {
+		blockStatusCode = defaultBlockStatusCode
+	}
+	// Blocked requests are errors, and WriteHeader panics on codes outside 100-999
+	if blockStatusCode < 400 || blockStatusCode > 599 {
+		return nil, fmt.Errorf("block status code %d is not a 4xx or 5xx status", blockStatusCode)
+	}
+
+	delimiter := config.SegmentDelimiter
+	if delimiter == "" {
+		delimiter = defaultDelimiter
+	}
+
+	preservedFiles := make(map[string]bool, len(config.PreserveFileNames))
+	for _, fileName := range config.PreserveFileNames {
+		preservedFiles[fileName] = true
+	}
+
+	formatSuffixes := make(map[string]bool, len(config.KeepFormatSuffix))
+	for _, suffix := range config.KeepFormatSuffix {
+		formatSuffixes[strings.TrimPrefix(suffix, ".")] = true
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	auditSampleRate := config.AuditSampleRate
+	if auditSampleRate < 1 {
+		auditSampleRate = defaultAuditSampleRate
+	}
+
+	switch config.OutputFormat {
+	case outputFormatDefault, outputFormatDatadog:
+	default:
+		return nil, fmt.Errorf("unknown output format %q", config.OutputFormat)
+	}
+
+	// Blocking and trailers need the group before next runs
+	if config.Lazy && (config.BlockThresholdIDs > 0 || config.UseTrailer) {
+		return nil, fmt.Errorf("lazy mode cannot be combined with blockThresholdIDs or useTrailer")
+	}
+
+	headBehavior := config.HeadRequestBehavior
+	switch headBehavior {
+	case "":
+		headBehavior = headBehaviorClassify
+	case headBehaviorClassify, headBehaviorSkip, headBehaviorCollapse:
+	default:
+		return nil, fmt.Errorf("unknown HEAD request behavior %q", config.HeadRequestBehavior)
+	}
+
+	uppercaseBehavior := config.UppercaseBehavior
+	switch uppercaseBehavior {
+	case "":
+		uppercaseBehavior = uppercasePreserve
+	case uppercasePreserve, uppercaseLabel, uppercaseLowercase:
+	default:
+		return nil, fmt.Errorf("unknown uppercase behavior %q", config.UppercaseBehavior)
+	}
+
+	emptySegments := config.EmptySegmentBehavior
+	switch emptySegments {
+	case "":
+		emptySegments = emptySegmentDrop
+	case emptySegmentDrop, emptySegmentPreserve, emptySegmentReject:
+	default:
+		return nil, fmt.Errorf("unknown empty segment behavior %q", config.EmptySegmentBehavior)
+	}
+
+	emptyPathValue := config.EmptyPathValue
+	if emptyPathValue == "" {
+		emptyPathValue = delimiter
+	}
+
+	redactionLabel := config.RedactionLabel
+	if redactionLabel == "" {
+		redactionLabel = defaultRedactionLabel
+	}
+
+	// Indexed by extension for lookup during classification
+	fileClasses := make(map[string]string)
+	for class, extensions := range config.FileClasses {
+		for _, extension := range extensions {
+			fileClasses[strings.ToLower(strings.TrimPrefix(extension, "."))] = class
+		}
+	}
+
+	// Prefixes are matched case-insensitively
+	prefixLabels := make(map[string]string, len(config.PrefixLabelMap))
+	for prefix, label := range config.PrefixLabelMap {
+		prefixLabels[strings.ToLower(prefix)] = label
+	}
+
+	actionVerbs := make(map[string]bool, len(config.ActionVerbs))
+	for _, verb := range config.ActionVerbs {
+		actionVerbs[verb] = true
+	}
+
+	compositeLabel := config.CompositeNumericLabel
+	if compositeLabel == "" {
+		compositeLabel = defaultCompositeLabel
+	}
+
+	compressionSuffixes := make(map[string]bool, len(config.CompressionSuffixes))
+	for _, suffix := range config.CompressionSuffixes {
+		compressionSuffixes[strings.ToLower(strings.TrimPrefix(suffix, "."))] = true
+	}
+
+	entropyMinLength := config.EntropyMinLength
+	if entropyMinLength == 0 {
+		entropyMinLength = defaultEntropyMinLen
+	}
+
+	inlineSeparator := config.InlineSeparator
+	if inlineSeparator == "" {
+		inlineSeparator = defaultInlineSeparator
+	}
+
+	unclassifiedRate := config.UnclassifiedSampleRate
+	if unclassifiedRate < 1 {
+		unclassifiedRate = 1
+	}
+
+	encodeChars := config.EncodeCharacters
+	if encodeChars == "" {
+		encodeChars = defaultEncodeChars
+	}
+
+	inlineMaxLength := config.InlineMaxLength
+	if inlineMaxLength == 0 {
+		inlineMaxLength = defaultInlineMaxLength
+	}
+
+	// Sorted by label so overlapping patterns match deterministically
+	structuredPatterns := make([]structuredPattern, 0, len(config.StructuredIDPatterns))
+	for label, expr := range config.StructuredIDPatterns {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid structured ID pattern for %q: %w", label, err)
+		}
+		structuredPatterns = append(structuredPatterns, structuredPattern{label: label, pattern: pattern})
+	}
+	sort.Slice(structuredPatterns, func(i, j int) bool {
+		return structuredPatterns[i].label < structuredPatterns[j].label
+	})
+
+	knownRoutes := make(map[string]bool, len(config.KnownRouteTemplates))
+	for _, template := range config.KnownRouteTemplates {
+		knownRoutes[template] = true
+	}
+
+	unmatchedValue := config.UnmatchedValue
+	if unmatchedValue == "" {
+		unmatchedValue = defaultUnmatchedValue
+	}
+
+	canonicalize := make(map[string]bool, len(config.CanonicalizeInsteadOfLabel))
+	for _, label := range config.CanonicalizeInsteadOfLabel {
+		canonicalize[label] = true
+	}
+
+	objectIDParents := make(map[string]bool, len(config.ObjectIDParents))
+	for _, parent := range config.ObjectIDParents {
+		objectIDParents[parent] = true
+	}
+
+	learnRate := config.LearnSampleRate
+	if learnRate < 1 {
+		learnRate = 1
+	}
+
+	routeSchemas := make([]routeSchema, 0, len(config.RouteSchemas))
+	for _, schema := range config.RouteSchemas {
+		routeSchemas = append(routeSchemas, routeSchema{
+			prefix: strings.Split(strings.Trim(schema.Prefix, delimiter), delimiter),
+			labels: schema.Labels,
+		})
+	}
+
+	compoundMinFraction := config.CompoundMinFraction
+	if compoundMinFraction <= 0 {
+		compoundMinFraction = 1
+	}
+
+	selfAliases := make(map[string]bool, len(config.SelfAliases))
+	for _, alias := range config.SelfAliases {
+		selfAliases[alias] = true
+	}
+
+	selfLabel := config.SelfLabel
+	if selfLabel == "" {
+		selfLabel = defaultSelfLabel
+	}
+
+	reserved := make(map[string]bool, len(config.ReservedSubresources))
+	for _, name := range config.ReservedSubresources {
+		reserved[name] = true
+	}
+
+	aggregateSuffixes := make(map[string]bool, len(config.AggregateSuffixes))
+	for _, suffix := range config.AggregateSuffixes {
+		aggregateSuffixes[suffix] = true
+	}
+
+	aggregateLabel := config.AggregateLabel
+	if aggregateLabel == "" {
+		aggregateLabel = defaultAggregateLabel
+	}
+
+	oauthStateKeys := make(map[string]bool, len(config.OAuthStateKeys))
+	if config.DetectOAuthState {
+		for _, key := range config.OAuthStateKeys {
+			oauthStateKeys[key] = true
+		}
+	}
+
+	var routeTrie *routeNode
+	if len(config.RouteTemplates) > 0 {
+		routeTrie = newRouteNode()
+		for _, template := range config.RouteTemplates {
+			routeTrie.insert(template, delimiter)
+		}
+	}
+
+	signatureKeys := make(map[string]bool, len(config.SignatureSuffixKeys))
+	for _, key := range config.SignatureSuffixKeys {
+		signatureKeys[key] = true
+	}
+
+	contentTypeGroups := make(map[string]string, len(config.ContentTypeGroups))
+	for contentType, group := range config.ContentTypeGroups {
+		contentTypeGroups[strings.ToLower(contentType)] = group
+	}
+
+	cursorLengths := make(map[int]bool, len(config.CursorLengths))
+	for _, length := range config.CursorLengths {
+		cursorLengths[length] = true
+	}
+
+	opaqueValue := config.OpaqueValue
+	if opaqueValue == "" {
+		opaqueValue = defaultOpaqueValue
+	}
+
+	methods := make(map[string]bool, len(config.Methods))
+	for _, method := range config.Methods {
+		methods[strings.ToUpper(method)] = true
+	}
+
+	a := &AddPathHeader{
+		next:                next,
+		headerName:          headerName,
+		name:                name,
+		blockThresholdIDs:   config.BlockThresholdIDs,
+		blockStatusCode:     blockStatusCode,
+		placeholder:         config.GenericPlaceholder,
+		detectBool:          config.DetectBool,
+		handleWellKnown:     config.HandleWellKnown,
+		delimiter:           delimiter,
+		lenientUUID:         config.LenientUUID,
+		preservedFiles:      preservedFiles,
+		useTrailer:          config.UseTrailer,
+		detectDateParts:     config.DetectDateParts,
+		formatSuffixes:      formatSuffixes,
+		slowLogThreshold:    time.Duration(config.SlowLogThresholdMs) * time.Millisecond,
+		logger:              logger,
+		now:                 time.Now,
+		auditHeaderName:     config.AuditHeaderName,
+		auditSampleRate:     uint64(auditSampleRate),
+		detectEmbeddedURL:   config.DetectEmbeddedURL,
+		failOpen:            config.FailOpen,
+		typedPrefixMode:     config.TypedPrefixMode,
+		methods:             methods,
+		dateAwareFiles:      config.DateAwareFiles,
+		dateFileKeepExt:     config.DateFileKeepExtension,
+		outputFormat:        config.OutputFormat,
+		localeAware:         config.LocaleAwareResource,
+		detectEtag:          config.DetectEtag,
+		emptyPathValue:      emptyPathValue,
+		detectIMEI:          config.DetectIMEI,
+		redactCardNumbers:   config.RedactCardNumbers,
+		redactionLabel:      redactionLabel,
+		fileClasses:         fileClasses,
+		headBehavior:        headBehavior,
+		paginationKeys:      config.PaginationKeys,
+		prefixLabels:        prefixLabels,
+		includeFragment:     config.IncludeFragment,
+		actionVerbs:         actionVerbs,
+		lazy:                config.Lazy,
+		validateUUIDVariant: config.ValidateUUIDVariant,
+		maxHeaders:          config.MaxHeadersPerRequest,
+		detectIDLists:       config.DetectIDLists,
+		onGroup:             config.OnGroup,
+		langPosition:        config.LangSegmentPosition,
+		compositeRun:        config.CompositeNumericRun,
+		compositeLabel:      compositeLabel,
+		compressionSuffixes: compressionSuffixes,
+		exactHosts:          make(map[string]*AddPathHeader),
+		entropyThreshold:    config.EntropyThreshold,
+		entropyMinLength:    entropyMinLength,
+		preservePrefixCase:  config.PreservePrefixCase,
+		detectPathDate:      config.DetectPathDate,
+		includeInline:       config.IncludeOriginalInline,
+		inlineSeparator:     inlineSeparator,
+		inlineMaxLength:     inlineMaxLength,
+		flagTraversal:       config.FlagTraversal,
+		blockTraversal:      config.BlockTraversal,
+		detectCursor:        config.DetectCursor,
+		detectPaseto:        config.DetectPaseto,
+		onUnclassified:      config.OnUnclassified,
+		unclassifiedRate:    uint64(unclassifiedRate),
+		normalizeDest:       config.NormalizeDestinationHeader,
+		detectStructuredID:  config.DetectStructuredID,
+		structuredPatterns:  structuredPatterns,
+		encodeOutput:        config.EncodeOutput,
+		encodeChars:         encodeChars,
+		detectTimezone:      config.DetectTimezone,
+		prefixDepth:         config.GroupPrefixDepth,
+		dotPrefix:           config.DotPrefixExtraction,
+		knownRoutes:         knownRoutes,
+		unmatchedValue:      unmatchedValue,
+		normalizeAssets:     config.NormalizeAssetHashes,
+		opaqueAbove:         config.OpaqueAboveSegments,
+		opaqueValue:         opaqueValue,
+		detectGitRefs:       config.DetectGitRefs,
+		uppercaseBehavior:   uppercaseBehavior,
+		sourceHeaders:       config.SourceHeaders,
+		detectRange:         config.DetectRange,
+		canonicalize:        canonicalize,
+		detectDeviceToken:   config.DetectDeviceToken,
+		queryStatsHeader:    config.QueryStatsHeaderName,
+		objectIDParents:     objectIDParents,
+		emptySegments:       emptySegments,
+		detectBase32:        config.DetectBase32,
+		learnSink:           config.LearnSink,
+		learnRate:           uint64(learnRate),
+		learned:             make(map[string]bool),
+		routeSchemas:        routeSchemas,
+		detectIBAN:          config.DetectIBAN,
+		compoundDelimiters:  config.CompoundDelimiters,
+		compoundMinFraction: compoundMinFraction,
+		selfAliases:         selfAliases,
+		selfLabel:           selfLabel,
+		grpcServiceHeader:   config.GRPCServiceHeaderName,
+		reserved:            reserved,
+		genericizeIDs:       config.GenericizeIDs,
+		detectGeohash:       config.DetectGeohash,
+		baggageKey:          config.BaggageKey,
+		riskHeader:          config.RiskHeaderName,
+		aggregateSuffixes:   aggregateSuffixes,
+		aggregateLabel:      aggregateLabel,
+		oauthStateKeys:      oauthStateKeys,
+		routeTrie:           routeTrie,
+		collapseNonASCII:    config.CollapseNonASCII,
+		fullTargetHeader:    config.FullTargetHeaderName,
+		signatureKeys:       signatureKeys,
+		maxDistinctGroups:   config.MaxDistinctGroups,
+		distinct:            make(map[string]bool),
+		detectTraceIDs:      config.DetectTraceIDs,
+		exampleSink:         config.ExampleSink,
+		exampleWindow:       config.ExampleWindow,
+		exampleSent:         make(map[string]time.Time),
+		detectMediaSuffix:   config.DetectMediaSuffix,
+		detectArrayIndex:    config.DetectArrayIndex,
+		contentTypeGroups:   sortedPrefixes(contentTypeGroups),
+		detectPlaceholders:  config.DetectPlaceholders,
+		includeName:         config.IncludeMiddlewareName,
+		traversalAfter:      config.CollapseTraversalAfter,
+		cursorLengths:       cursorLengths,
+		prefixHeaders:       sortedPrefixes(config.PrefixHeaders),
+		detectHexGroups:     config.DetectHexGroups,
+		pathMetrics:         config.PathMetricsHeaders,
+	}
+	a.classify = a.identifyIDType
+	if config.LegacyStarMode {
+		a.classify = legacyStarType
+	}
+
+	return a, nil
+}
+
+// structuredPattern is a configured structured ID pattern and the label it emits
+type structuredPattern struct {
+	label   string
+	pattern *regexp.Regexp
+}
+
+// prefixValue is a value configured for the strings starting with prefix
+type prefixValue struct {
+	prefix string
+	value  string
+}
+
+// sortedPrefixes lists the entries of a prefix map longest prefix first, so the most specific one matches
+func sortedPrefixes(values map[string]string) []prefixValue {
+	sorted := make([]prefixValue, 0, len(values))
+	for prefix, value := range values {
+		sorted = append(sorted, prefixValue{prefix: prefix, value: value})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].prefix) != len(sorted[j].prefix) {
+			return len(sorted[i].prefix) > len(sorted[j].prefix)
+		}
+		return sorted[i].prefix < sorted[j].prefix
+	})
+	return sorted
+}
+
+// matchPrefix returns the value of the longest prefix s starts with
+func matchPrefix(values []prefixValue, s string) (string, bool) {
+	for _, v := range values {
+		if strings.HasPrefix(s, v.prefix) {
+			return v.value, true
+		}
+	}
+	return "", false
+}
+
+// routeSchema is a route schema with its prefix split into segments
+type routeSchema struct {
+	prefix []string
+	labels []string
+}
+
+// routeNode is a node of the route template trie. Literal segments are children
+// keyed by name, and a {placeholder} segment matches any single segment.
+type routeNode struct {
+	children map[string]*routeNode
+	wildcard *routeNode
+	// template is the route template ending at this node, if any
+	template string
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{children: make(map[string]*routeNode)}
+}
+
+// insert adds a route template to the trie
+func (n *routeNode) insert(template, delimiter string) {
+	node := n
+	for _, segment := range strings.Split(strings.Trim(template, delimiter), delimiter) {
+		if segment == "" {
+			continue
+		}
+
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if node.wildcard == nil {
+				node.wildcard = newRouteNode()
+			}
+			node = node.wildcard
+			continue
+		}
+
+		child, ok := node.children[segment]
+		if !ok {
+			child = newRouteNode()
+			node.children[segment] = child
+		}
+		node = child
+	}
+	node.template = template
+}
+
+// match returns the template matching all the segments, preferring literal segments over placeholders
+func (n *routeNode) match(segments []string) (string, bool) {
+	for len(segments) > 0 && segments[0] == "" {
+		segments = segments[1:]
+	}
+	if len(segments) == 0 {
+		return n.template, n.template != ""
+	}
+
+	if child, ok := n.children[segments[0]]; ok {
+		if template, ok := child.match(segments[1:]); ok {
+			return template, true
+		}
+	}
+	if n.wildcard != nil {
+		return n.wildcard.match(segments[1:])
+	}
+	return "", false
+}
+
+// hostOverride is a wildcard host override matching hosts ending with suffix (e.g. .example.com)
+type hostOverride struct {
+	suffix  string
+	handler *AddPathHeader
+}
+
+// normalizeHost lowercases a host and strips its port
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// forHost returns the host override matching the request host, or nil when the base configuration applies
+func (a *AddPathHeader) forHost(host string) *AddPathHeader {
+	if len(a.exactHosts) == 0 && len(a.wildcardHosts) == 0 {
+		return nil
+	}
+
+	host = normalizeHost(host)
+
+	if handler, ok := a.exactHosts[host]; ok {
+		return handler
+	}
+	for _, override := range a.wildcardHosts {
+		if strings.HasSuffix(host, override.suffix) {
+			return override.handler
+		}
+	}
+	return nil
+}
+
+// identifyIDType identifies the type of ID in a segment, checking patterns in order of specificity.
+// Returns the ID type label if matched, empty string otherwise.
+// Also handles prefixed IDs (e.g., "prefix:uuid", "prefix_nanoid").
+func (a *AddPathHeader) identifyIDType(segment string) string {
+	if segment == "" {
+		return ""
+	}
+
+	// Reserved sub-resources stay literal whatever the enabled detectors
+	if a.reserved[segment] {
+		return ""
+	}
+
+	// Self aliases stand for the authenticated user's ID
+	if a.selfAliases[segment] {
+		return a.selfLabel
+	}
+
+	// User-generated unicode slugs are unbounded (opt-in, segment has already been decoded)
+	if a.collapseNonASCII && hasNonASCII(segment) {
+		return labelUnicode
+	}
+
+	// Check embedded URLs (opt-in, segment has already been decoded)
+	if a.detectEmbeddedURL && embeddedURLPattern.MatchString(segment) {
+		return labelURL
+	}
+
+	// Check entity tags (opt-in, quoted so they cannot be confused with bare hex IDs)
+	if a.detectEtag && isEtag(segment) {
+		return labelEtag
+	}
+
+	// Check boolean literals (opt-in, exact match only)
+	if a.detectBool && boolPattern.MatchString(segment) {
+		return labelBool
+	}
+
+	// Check PASETO tokens (opt-in, before dotted segments are treated as file names)
+	if a.detectPaseto && pasetoPattern.MatchString(segment) {
+		return labelToken
+	}
+
+	// Check time zone identifiers kept in one segment by an encoded separator (opt-in)
+	if a.detectTimezone && timezoneNames[segment] {
+		return labelTimezone
+	}
+
+	// Check configured structured ID patterns, which take precedence over built-in detectors
+	for _, p := range a.structuredPatterns {
+		if p.pattern.MatchString(segment) {
+			return p.label
+		}
+	}
+
+	// Check built-in structured IDs (opt-in, these would otherwise be labeled slug)
+	if a.detectStructuredID && structuredIDPattern.MatchString(segment) {
+		return labelStructID
+	}
+
+	// Check push tokens (opt-in, before FCM tokens are split on their colon as prefixed IDs)
+	if a.detectDeviceToken && (apnsTokenPattern.MatchString(segment) || fcmTokenPattern.MatchString(segment)) {
+		return labelDevice
+	}
+
+	// Check IBANs (opt-in, checksummed so they cannot be confused with other alphanumeric IDs)
+	if a.detectIBAN && ibanPattern.MatchString(segment) && ibanValid(segment) {
+		return labelIBAN
+	}
+
+	// Check synthetic test IDs (opt-in, before the UUID and number detectors they would otherwise match)
+	if a.detectPlaceholders && isPlaceholder(segment) {
+		return labelPlaceholder
+	}
+
+	// 1. Check UUID (unique dash structure, 36 chars)
+	if uuidPattern.MatchString(segment) && (!a.validateUUIDVariant || hasRFC4122Variant(segment)) {
+		return labelUUID
+	}
+
+	// Check W3C trace and span IDs (opt-in, before dashless hex is taken for a UUID; all-digit values stay numeric)
+	if a.detectTraceIDs && !numericPattern.MatchString(segment) {
+		if traceIDPattern.MatchString(segment) {
+			return labelTraceID
+		}
+		if spanIDPattern.MatchString(segment) {
+			return labelSpanID
+		}
+	}
+
+	// Check non-standard UUID dash groupings (opt-in)
+	if a.lenientUUID && hex32Pattern.MatchString(strings.ReplaceAll(segment, "-", "")) {
+		return labelUUID
+	}
+
+	// Check array indices (opt-in, before plain numbers are taken for IDs)
+	if a.detectArrayIndex && arrayIndexPattern.MatchString(segment) {
+		return labelIndex
+	}
+
+	// 2. Check Numeric (digits only, unambiguous)
+	if numericPattern.MatchString(segment) {
+		// Device IMEIs are 15-digit Luhn-valid numbers (opt-in)
+		if a.detectIMEI && len(segment) == imeiLength && luhnValid(segment) {
+			return labelIMEI
+		}
+		// Anything that could be a card number never reaches metrics or logs
+		if a.redactCardNumbers && isCardNumber(segment) {
+			return a.redactionLabel
+		}
+		return labelNumericID
+	}
+
+	// 3. Check ISO Date/Datetime (YYYY-MM-DD with optional time and timezone)
+	if isoDatePattern.MatchString(segment) {
+		return labelISODate
+	}
+
+	// 4. Check ULID (26 chars, specific charset)
+	if ulidPattern.MatchString(segment) {
+		return labelULID
+	}
+
+	// 5. Check CUID (25 chars, starts with 'c')
+	if cuidPattern.MatchString(segment) {
+		return labelCUID
+	}
+
+	// 6. Check CUID2 (24 chars, starts with lowercase)
+	if cuid2Pattern.MatchString(segment) {
+		return labelCUID2
+	}
+
+	// 7. Check NanoID (21 chars, broader charset, must contain a digit)
+	if len(segment) == 21 && nanoidPattern.MatchString(segment) {
+		return labelNanoID
+	}
+
+	// Check cursors of the configured sizes (after the fixed-length IDs above, which keep their label)
+	if a.cursorLengths[len(segment)] && rawURLBase64Pattern.MatchString(segment) {
+		if _, err := base64.RawURLEncoding.DecodeString(segment); err == nil {
+			return labelCursor
+		}
+	}
+
+	// Check base32 (opt-in, after ULID whose alphabet overlaps it)
+	if a.detectBase32 && isBase32(segment) {
+		return labelBase32
+	}
+
+	// Check geohashes (opt-in, lower case so they cannot collide with ULIDs).
+	// Numbers were labeled above and digits are required, so words are not mistaken for geohashes.
+	if a.detectGeohash && isGeohash(segment) {
+		return labelGeohash
+	}
+
+	// Versioned media types in the path keep their base (e.g. 42.v2+json -> numeric_id.media_type) (opt-in)
+	if a.detectMediaSuffix {
+		if match := mediaSuffixPattern.FindStringSubmatch(segment); match != nil {
+			base := match[1]
+			if label := a.identifyIDType(base); label != "" {
+				base = label
+			}
+			return base + "." + labelMedia
+		}
+	}
+
+	// 8. Check File (segments ending with file extension like .html, .css, .js, .png)
+	// Content-negotiation suffixes on an ID keep the format (e.g. 42.json -> numeric_id.json)
+	if idx := strings.LastIndex(segment, "."); idx > 0 && a.formatSuffixes[segment[idx+1:]] {
+		if label := a.identifyIDType(segment[:idx]); label != "" && label != labelFile {
+			return label + segment[idx:]
+		}
+	}
+
+	// Compound segments keep their structure, with each part classified (e.g. 42|abc-1 -> numeric_id|slug)
+	if compound := a.compoundLabel(segment); compound != "" {
+		return compound
+	}
+
+	// Check batch ID lists like 1,2,3 or uuid1;uuid2 (opt-in)
+	if a.detectIDLists && a.isIDList(segment) {
+		return labelIDList
+	}
+
+	// Precompressed assets keep their logical type (e.g. app.js.gz -> file.js)
+	if idx := strings.LastIndex(segment, "."); idx > 0 && a.compressionSuffixes[strings.ToLower(segment[idx+1:])] {
+		if inner := segment[:idx]; strings.LastIndex(inner, ".") > 0 {
+			label := a.identifyIDType(inner)
+			// Only a plain extension is kept, a numeric one (e.g. app.1708900000.gz) may be an ID or timestamp
+			if ext := inner[strings.LastIndex(inner, ".")+1:]; label == labelFile && fileExtensionPattern.MatchString(ext) {
+				return labelFile + "." + ext
+			}
+			if label != "" {
+				return label
+			}
+		}
+	}
+
+	// Dotted prefixes (e.g. t.<uuid>) would otherwise be treated as a file name with the ID as its extension.
+	// Only non-numeric ID patterns are extracted, so numbered files like backup.001 stay files.
+	if idx := strings.Index(segment, "."); a.dotPrefix && idx > 0 {
+		prefix := segment[:idx]
+		suffix := segment[idx+1:]
+		if prefixPattern.MatchString(prefix) &&
+			(uuidPattern.MatchString(suffix) ||
+				ulidPattern.MatchString(suffix) ||
+				cuidPattern.MatchString(suffix) ||
+				cuid2Pattern.MatchString(suffix) ||
+				(len(suffix) == 21 && nanoidPattern.MatchString(suffix))) {
+			if label := a.identifyIDType(suffix); label != "" {
+				return a.prefixedLabel(prefix, label)
+			}
+		}
+	}
+
+	// Known entrypoints like index.html are kept literal
+	if filePattern.MatchString(segment) {
+		if a.preservedFiles[segment] {
+			return ""
+		}
+		if a.dateAwareFiles {
+			if label := a.dateFileLabel(segment); label != "" {
+				return label
+			}
+		}
+		if class, ok := a.fileClasses[strings.ToLower(segment[strings.LastIndex(segment, ".")+1:])]; ok {
+			return labelFile + "_" + class
+		}
+		return labelFile
+	}
+
+	// Check typed prefixed IDs (opt-in), keeping the semantically useful prefix
+	if a.typedPrefixMode {
+		if label := typedPrefixLabel(segment); label != "" {
+			return label
+		}
+	}
+
+	// 9. Try prefix extraction (check for prefix:ID or prefix_ID)
+	// Try colon separator first (unambiguous)
+	if idx := strings.Index(segment, ":"); idx > 0 {
+		prefix := segment[:idx]
+		suffix := segment[idx+1:]
+		if suffix != "" && (prefixPattern.MatchString(prefix) || a.identifyIDType(prefix) != "") {
+			if label := a.identifyIDType(suffix); label != "" {
+				return a.prefixedLabel(prefix, label)
+			}
+		}
+	}
+
+	// Try underscore separator (can appear in NanoID, but we already checked full segment)
+	// For underscore, treat as prefixed ID if:
+	// - Suffix matches non-numeric ID patterns (UUID, ULID, CUID, CUID2, NanoID, ISO Date), OR
+	// - Suffix is numeric with 3+ digits (longer numeric IDs are more likely to be prefixed)
+	// Shorter numeric suffixes (1-2 digits) are more likely to be slugs like "user_42"
+	if idx := strings.Index(segment, "_"); idx > 0 {
+		prefix := segment[:idx]
+		suffix := segment[idx+1:]
+		if prefixPattern.MatchString(prefix) && suffix != "" {
+			// Check if suffix matches a non-numeric ID pattern
+			if uuidPattern.MatchString(suffix) ||
+				isoDatePattern.MatchString(suffix) ||
+				ulidPattern.MatchString(suffix) ||
+				cuidPattern.MatchString(suffix) ||
+				cuid2Pattern.MatchString(suffix) ||
+				(len(suffix) == 21 && nanoidPattern.MatchString(suffix)) {
+				// Recursively identify the ID type
+				if label := a.identifyIDType(suffix); label != "" {
+					return a.prefixedLabel(prefix, label)
+				}
+			} else if numericPattern.MatchString(suffix) && len(suffix) >= 3 {
+				// Numeric suffix with 3+ digits - treat as prefixed numeric ID
+				return a.prefixedLabel(prefix, labelNumericID)
+			}
+		}
+	}
+
+	// Opaque pagination cursors are checked before slugs, which unpadded base64 would otherwise match
+	if a.detectCursor && isCursor(segment) {
+		return labelCursor
+	}
+
+	// Numeric ranges have digits and a separator, so they are checked before slugs
+	if a.detectRange && rangePattern.MatchString(segment) {
+		return labelRange
+	}
+
+	// Partial UUIDs and shard keys would otherwise be labeled slug (opt-in, full UUIDs were labeled above).
+	// Letters and digits are both required so hyphenated hex-looking words (e.g. dead-beef) stay literal.
+	if a.detectHexGroups && hexGroupsPattern.MatchString(segment) && strings.ContainsAny(segment, "0123456789") && strings.ContainsAny(segment, "abcdef") {
+		return labelHexKey
+	}
+
+	// 10. Check slug (alphanumeric with digits and separators)
+	if slugPattern.MatchString(segment) {
+		hasDigit := false
+		hasLetter := false
+		hasSeparator := false
+		for _, r := range segment {
+			if r >= '0' && r <= '9' {
+				hasDigit = true
+			}
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				hasLetter = true
+			}
+			if r == '-' || r == '_' {
+				hasSeparator = true
+			}
+		}
+		if hasDigit && hasSeparator {
+			return labelSlug
+		}
+		// Also match purely alphanumeric segments (no separators) that mix letters and digits
+		// and are at least 8 characters long (avoids false positives with short version prefixes)
+		if hasDigit && hasLetter && !hasSeparator && len(segment) >= 8 {
+			return labelSlug
+		}
+	}
+
+	// 11. Catch-all for random tokens no pattern matched (opt-in)
+	if a.entropyThreshold > 0 && len(segment) >= a.entropyMinLength && shannonEntropy(segment) > a.entropyThreshold {
+		return labelRandom
+	}
+
+	return ""
+}
+
+// shannonEntropy returns the Shannon entropy of a string in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// dateFileLabel returns the label for a file whose base name is a date or datetime (e.g. 2024-02-26T00-01-55.log).
+// Returns empty string when the base name is not a date.
+func (a *AddPathHeader) dateFileLabel(segment string) string {
+	idx := strings.LastIndex(segment, ".")
+	if idx <= 0 || !dateFileBasePattern.MatchString(segment[:idx]) {
+		return ""
+	}
+	if a.dateFileKeepExt {
+		return labelDate + segment[idx:]
+	}
+	return labelISODate
+}
+
+// isEtag reports whether a segment is a quoted strong ("...") or weak (W/"...") entity tag.
+// The quotes and weak prefix are stripped and the opaque part must be hex containing a digit.
+func isEtag(segment string) bool {
+	segment = strings.TrimPrefix(segment, weakEtagPrefix+"/")
+	if len(segment) < 2 || segment[0] != '"' || segment[len(segment)-1] != '"' {
+		return false
+	}
+
+	value := segment[1 : len(segment)-1]
+	return len(value) >= minEtagLength && etagValuePattern.MatchString(value) && strings.ContainsAny(value, "0123456789")
+}
+
+// isIDList reports whether a segment is a comma- or semicolon-separated list of two or more IDs
+func (a *AddPathHeader) isIDList(segment string) bool {
+	parts := strings.FieldsFunc(segment, func(r rune) bool { return r == ',' || r == ';' })
+	if len(parts) < 2 || strings.Count(segment, ",")+strings.Count(segment, ";") != len(parts)-1 {
+		return false
+	}
+
+	for _, part := range parts {
+		if a.identifyIDType(part) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRFC4122Variant reports whether a UUID-shaped segment has the RFC 4122 variant bits (10xx),
+// i.e. the first hex digit of the fourth group is 8, 9, a or b
+func hasRFC4122Variant(segment string) bool {
+	switch segment[uuidVariantIndex] {
+	case '8', '9', 'a', 'b', 'A', 'B':
+		return true
+	}
+	return false
+}
+
+// isCardNumber reports whether a segment could be a card number: 13 to 19 Luhn-valid digits,
+// optionally bracketed as an array index
+func isCardNumber(segment string) bool {
+	digits := strings.TrimSuffix(strings.TrimPrefix(segment, "["), "]")
+	return len(digits) >= minCardNumberLength && len(digits) <= maxCardNumberLength && numericPattern.MatchString(digits) && luhnValid(digits)
+}
+
+// redacted reports whether the original value of a replaced segment must never be emitted.
+// The value is checked rather than its label, as other detectors (e.g. array indices, pagination keys) may have labeled it.
+func (a *AddPathHeader) redacted(r replacement) bool {
+	return a.redactCardNumbers && isCardNumber(r.original)
+}
+
+// logPath returns a path safe to log, with segments that could be card numbers replaced by the redaction label
+func (a *AddPathHeader) logPath(path string) string {
+	if !a.redactCardNumbers {
+		return path
+	}
+	segments := strings.Split(path, a.delimiter)
+	for i, segment := range segments {
+		if isCardNumber(segment) {
+			segments[i] = a.redactionLabel
+		}
+	}
+	return strings.Join(segments, a.delimiter)
+}
+
+// luhnValid reports whether a string of digits passes the Luhn checksum
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// legacyStarType is the reduced classification of legacy star mode: UUIDs, numbers
+// and slugs mixing digits with separators are labeled *, everything else stays literal.
+func legacyStarType(segment string) string {
+	if uuidPattern.MatchString(segment) || numericPattern.MatchString(segment) {
+		return legacyStarLabel
+	}
+	if slugPattern.MatchString(segment) && strings.ContainsAny(segment, "0123456789") && strings.ContainsAny(segment, "-_") {
+		return legacyStarLabel
+	}
+	return ""
+}
+
+// typedPrefixLabel returns <prefix>_id for Stripe-style (cus_Nv8f2h8) and Twilio-style (AC<32hex>) IDs.
+// Returns empty string when the segment is not a typed ID.
+func typedPrefixLabel(segment string) string {
+	if m := typedPrefixPattern.FindStringSubmatch(segment); m != nil && len(m[2]) >= minTypedIDBodyLength {
+		return m[1] + typedIDSuffix
+	}
+	if m := twilioSIDPattern.FindStringSubmatch(segment); m != nil {
+		return m[1] + typedIDSuffix
+	}
+	return ""
+}
+
+// prefixedLabel returns the label for an ID extracted from a prefixed segment,
+// carrying the mapped prefix type (e.g. user_uuid) when the prefix is in the prefix label map.
+// The prefix portion is lowercased unless its original casing is preserved (e.g. USR_uuid).
+func (a *AddPathHeader) prefixedLabel(prefix, label string) string {
+	mapped, ok := a.prefixLabels[strings.ToLower(prefix)]
+	if !ok {
+		return label
+	}
+	if a.preservePrefixCase {
+		return prefix + "_" + label
+	}
+	return strings.ToLower(mapped) + "_" + label
+}
+
+// keyedValueLabel labels a segment based on the key segment preceding it (e.g. /page/2).
+// Returns empty string when the previous segment is not a configured key.
+func (a *AddPathHeader) keyedValueLabel(previous []string, segment string) string {
+	if len(previous) == 0 {
+		return ""
+	}
+	key := previous[len(previous)-1]
+
+	if label, ok := a.paginationKeys[key]; ok && numericPattern.MatchString(segment) {
+		return label
+	}
+
+	// Signed URLs append their signature after a key segment (e.g. /sig/<hmac>)
+	if a.signatureKeys[key] && signaturePattern.MatchString(segment) {
+		return labelSignature
+	}
+
+	// OAuth state and nonce values are random per request, so words after the key (e.g. /code/items) are not values
+	if a.oauthStateKeys[key] && looksLikeID(segment) {
+		return labelToken
+	}
+
+	// Bare 24-hex strings are ambiguous, so ObjectIds are only recognized under known collections
+	if a.objectIDParents[key] && objectIDPattern.MatchString(segment) {
+		return labelObjectID
+	}
+
+	return ""
+}
+
+// numericRunLength returns the length of the run of consecutive numeric segments starting at i.
+// Returns 0 when i is not the start of a run, so a longer run is never matched from its middle.
+func numericRunLength(segments []string, i int) int {
+	if i > 0 && numericPattern.MatchString(segments[i-1]) {
+		return 0
+	}
+
+	n := 0
+	for i+n < len(segments) && numericPattern.MatchString(segments[i+n]) {
+		n++
+	}
+	return n
+}
+
+// isDateParts reports whether the first three segments form a plausible MM/DD/YYYY or DD/MM/YYYY date
+func isDateParts(segments []string) bool {
+	if len(segments) < 3 || len(segments[0]) > 2 || len(segments[1]) > 2 || len(segments[2]) != 4 {
+		return false
+	}
+
+	parts := make([]int, 3)
+	for i, segment := range segments[:3] {
+		if !numericPattern.MatchString(segment) {
+			return false
+		}
+		parts[i], _ = strconv.Atoi(segment)
+	}
+
+	first, second := parts[0], parts[1]
+	if first < 1 || second < 1 {
+		return false
+	}
+
+	return (first <= 12 && second <= 31) || (first <= 31 && second <= 12)
+}
+
+// isPathDate reports whether the first three segments are year/month/day directories of a valid calendar date
+func isPathDate(segments []string) bool {
+	if len(segments) < 3 || len(segments[0]) != 4 {
+		return false
+	}
+
+	_, err := time.Parse("2006/1/2", strings.Join(segments[:3], "/"))
+	return err == nil
+}
+
+// hasTraversal reports whether the path contains a .. segment
+func hasTraversal(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlaceholder reports whether a segment is an obviously synthetic ID: the nil UUID,
+// a repeated digit (e.g. 0000) or a run of sequential digits (e.g. 123456789)
+func isPlaceholder(segment string) bool {
+	if segment == nilUUID {
+		return true
+	}
+	if !numericPattern.MatchString(segment) {
+		return false
+	}
+	if len(segment) >= minPlaceholderRepeat && strings.Count(segment, segment[:1]) == len(segment) {
+		return true
+	}
+	if len(segment) < minPlaceholderSequence {
+		return false
+	}
+	for i := 1; i < len(segment); i++ {
+		if segment[i] != '0'+(segment[i-1]-'0'+1)%10 {
+			return false
+		}
+	}
+	return true
+}
+
+// ibanValid reports whether an IBAN passes the ISO 13616 mod-97 checksum.
+// The country code and check digits are moved to the end and letters are expanded to two digits (A=10 ... Z=35).
+func ibanValid(iban string) bool {
+	remainder := 0
+	for _, r := range iban[4:] + iban[:4] {
+		if r >= 'A' && r <= 'Z' {
+			remainder = (remainder*100 + int(r-'A') + 10) % 97
+		} else {
+			remainder = (remainder*10 + int(r-'0')) % 97
+		}
+	}
+	return remainder == 1
+}
+
+// compoundLabel splits a segment on the first configured compound delimiter it contains and classifies each part.
+// Returns an empty string when there is no delimiter or too few parts are classified.
+func (a *AddPathHeader) compoundLabel(segment string) string {
+	for _, delimiter := range a.compoundDelimiters {
+		if delimiter == "" || !strings.Contains(segment, delimiter) {
+			continue
+		}
+
+		parts := strings.Split(segment, delimiter)
+		classified := 0
+		for i, part := range parts {
+			if label := a.identifyIDType(part); label != "" {
+				parts[i] = label
+				classified++
+			}
+		}
+		if float64(classified) >= a.compoundMinFraction*float64(len(parts)) {
+			return strings.Join(parts, delimiter)
+		}
+		return ""
+	}
+	return ""
+}
+
+// hasNonASCII reports whether a segment contains a byte outside the ASCII range
+func hasNonASCII(segment string) bool {
+	for i := 0; i < len(segment); i++ {
+		if segment[i] > 127 {
+			return true
+		}
+	}
+	return false
+}
+
+// isCursor reports whether a segment is a base64 cursor (e.g. eyJpZCI6MTIzfQ==).
+// Unpadded segments must also mix upper case, lower case and digits, so words and slugs are not mistaken for cursors.
+func isCursor(segment string) bool {
+	if len(segment) < minCursorLength {
+		return false
+	}
+
+	if strings.HasSuffix(segment, "=") {
+		_, stdErr := base64.StdEncoding.DecodeString(segment)
+		_, urlErr := base64.URLEncoding.DecodeString(segment)
+		return stdErr == nil || urlErr == nil
+	}
+
+	hasUpper, hasLower, hasDigit := false, false, false
+	for _, r := range segment {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			hasUpper = true
+		case r >= 'a' && r <= 'z':
+			hasLower = true
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		}
+	}
+	if !hasUpper || !hasLower || !hasDigit {
+		return false
+	}
+
+	_, stdErr := base64.RawStdEncoding.DecodeString(segment)
+	_, urlErr := base64.RawURLEncoding.DecodeString(segment)
+	return stdErr == nil || urlErr == nil
+}
+
+// looksLikeID reports whether an unclassified segment mixes letters and digits and is long enough to be an unknown ID format
+func looksLikeID(segment string) bool {
+	if len(segment) < minUnclassifiedLength {
+		return false
+	}
+
+	hasDigit, hasLetter := false, false
+	for _, r := range segment {
+		if r >= '0' && r <= '9' {
+			hasDigit = true
+		}
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			hasLetter = true
+		}
+	}
+	return hasDigit && hasLetter
+}
+
+// encodeLiteral percent-encodes the configured reserved characters in a literal segment.
+// Detector labels never contain reserved characters and are not passed through it.
+func (a *AddPathHeader) encodeLiteral(segment string) string {
+	if !a.encodeOutput || !strings.ContainsAny(segment, a.encodeChars) {
+		return segment
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		if c := segment[i]; strings.IndexByte(a.encodeChars, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// timezoneSegments returns the number of leading segments forming a known time zone identifier
+// (e.g. 2 for America/New_York, 3 for America/Argentina/Buenos_Aires), or 0 when there is none.
+func (a *AddPathHeader) timezoneSegments(segments []string) int {
+	if !a.detectTimezone {
+		return 0
+	}
+
+	for n := 3; n >= 2; n-- {
+		if len(segments) >= n && timezoneNames[strings.Join(segments[:n], "/")] {
+			return n
+		}
+	}
+	return 0
+}
+
+// stripAssetHash removes content hashes between the name and extension of an asset (e.g. main.4f2a9b.chunk.js).
+// Returns false when normalization is disabled or the segment has no hash.
+func (a *AddPathHeader) stripAssetHash(segment string) (string, bool) {
+	if !a.normalizeAssets {
+		return "", false
+	}
+
+	parts := strings.Split(segment, ".")
+	if len(parts) < 3 {
+		return "", false
+	}
+
+	kept := []string{parts[0]}
+	for _, part := range parts[1 : len(parts)-1] {
+		if len(part) >= minAssetHashLength && assetHashPattern.MatchString(part) {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	if len(kept) == len(parts)-1 {
+		return "", false
+	}
+	return strings.Join(append(kept, parts[len(parts)-1]), "."), true
+}
+
+// isGitRefPrefix reports whether the previous segments end with refs/heads or refs/tags
+func isGitRefPrefix(previous []string) bool {
+	n := len(previous)
+	return n >= 2 && previous[n-2] == "refs" && (previous[n-1] == "heads" || previous[n-1] == "tags")
+}
+
+// isUppercase reports whether a segment has letters and none of them are lower case (e.g. STATUS or V2_API)
+func isUppercase(segment string) bool {
+	hasUpper := false
+	for _, r := range segment {
+		if r >= 'a' && r <= 'z' {
+			return false
+		}
+		if r >= 'A' && r <= 'Z' {
+			hasUpper = true
+		}
+	}
+	return hasUpper
+}
+
+// canonicalID returns the canonical form of a detected ID: lowercased, with UUIDs in their dashed 8-4-4-4-12 layout
+func canonicalID(label, segment string) string {
+	canonical := strings.ToLower(segment)
+	if label != labelUUID {
+		return canonical
+	}
+
+	hex := strings.ReplaceAll(canonical, "-", "")
+	if len(hex) != 32 {
+		return canonical
+	}
+	return hex[:8] + "-" + hex[8:12] + "-" + hex[12:16] + "-" + hex[16:20] + "-" + hex[20:]
+}
+
+// queryParamCount returns the number of parameters in a raw query string, ignoring empty pairs (e.g. a=1&&b)
+func queryParamCount(rawQuery string) int {
+	count := 0
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// isGeohash reports whether a segment is a lower case geohash (e.g. u4pruydqqvj) long and mixed enough not to be a word
+// or a versioned token.
+func isGeohash(segment string) bool {
+	if len(segment) < minGeohashLength || !geohashPattern.MatchString(segment) {
+		return false
+	}
+
+	digits := 0
+	for i := 0; i < len(segment); i++ {
+		if segment[i] >= '0' && segment[i] <= '9' {
+			digits++
+		}
+	}
+	return digits >= 2 || (digits == 1 && len(segment) >= minMixedGeohashLength)
+}
+
+// isBase32 reports whether a segment is upper case base32 (e.g. JBSWY3DPEHPK3PXP).
+// Unpadded segments must contain a digit so upper case words are not mistaken for base32.
+func isBase32(segment string) bool {
+	if len(segment) < minBase32Length || !base32Pattern.MatchString(segment) {
+		return false
+	}
+	if strings.HasSuffix(segment, "=") {
+		return len(segment)%8 == 0
+	}
+	return strings.ContainsAny(segment, "234567")
+}
+
+// replacement records a path segment that was replaced by a label
+type replacement struct {
+	// position is the index of the label in the path group segments
+	position int
+	original string
+	label    string
+}
+
+// extractPathGroup normalizes a path by replacing ID segments with their type labels.
+// Also returns the segments that were detected as IDs and the cardinality risk of the group.
+func (a *AddPathHeader) extractPathGroup(path string) (string, []replacement, int) {
+	if path == "" || path == a.delimiter {
+		return a.emptyPathValue, nil, 0
+	}
+
+	// gRPC methods are a fixed set, the service would otherwise be labeled as a file name
+	if a.grpcServiceHeader != "" && grpcPathPattern.MatchString(path) {
+		return path, nil, 0
+	}
+
+	trimmed := strings.Trim(path, a.delimiter)
+
+	// Known routes are matched as a whole, bounding the output to the templates
+	if a.routeTrie != nil {
+		segments := strings.Split(trimmed, a.delimiter)
+		if template, ok := a.routeTrie.match(segments); ok {
+			return template, nil, cardinalityRisk(strings.Split(strings.Trim(template, a.delimiter), a.delimiter))
+		}
+		// No segment of an unmatched path was classified, so all of them are literals
+		return a.unmatchedValue, nil, cardinalityRisk(segments)
+	}
+
+	// Abusively deep paths are not split at all
+	if a.opaqueAbove > 0 && strings.Count(trimmed, a.delimiter)+1 > a.opaqueAbove {
+		return a.opaqueValue, nil, 0
+	}
+
+	segments := strings.Split(trimmed, a.delimiter)
+	result := make([]string, 0, len(segments))
+	var replaced []replacement
+	// literals holds the positions of the plain literal segments, the ones scored for cardinality risk
+	var literals []int
+
+	// Well-known URIs are fixed names, so only the ACME challenge token is grouped
+	wellKnown := a.handleWellKnown && segments[0] == wellKnownSegment
+
+	// The language position is counted from the resource, so it moves past a collapsed leading locale
+	langIndex := a.langPosition - 1
+
+	for i := 0; i < len(segments); i++ {
+		segment := segments[i]
+		if segment == "" {
+			if a.emptySegments == emptySegmentPreserve {
+				result = append(result, labelEmpty)
+			}
+			continue
+		}
+
+		// The path is still escaped so encoded separators stay within their segment
+		if a.detectEmbeddedURL {
+			if decoded, err := url.PathUnescape(segment); err == nil {
+				segment = decoded
+			}
+		}
+
+		// Reserved sub-resources stay literal whatever the enabled detectors, including the cross-segment ones
+		reserved := a.reserved[segment]
+
+		var label string
+		if wellKnown {
+			if i > 0 && segments[i-1] == acmeChallengeSegment {
+				label = labelToken
+			}
+		} else if reserved {
+			label = ""
+		} else if a.localeAware && i == 0 && localePattern.MatchString(segment) {
+			// A leading locale is collapsed so the resource that follows groups the same with or without it
+			label = labelLocale
+			langIndex++
+		} else if a.langPosition > 0 && i == langIndex && languageCodes[segment] {
+			// Language codes are only recognized at the configured position to avoid matching short words
+			label = labelLang
+		} else if a.detectEtag && segment == weakEtagPrefix && i+1 < len(segments) && isEtag(segments[i+1]) {
+			// A weak etag's W/ prefix is split from its quoted value by the path separator
+			segment = strings.Join(segments[i:i+2], a.delimiter)
+			label = labelEtag
+			i++
+		} else if a.detectGitRefs && isGitRefPrefix(segments[:i]) {
+			// Branch names may contain the path separator (feature/xyz), so the rest of the path is the ref
+			segment = strings.Join(segments[i:], a.delimiter)
+			label = labelRef
+			i = len(segments) - 1
+		} else if keyed := a.keyedValueLabel(segments[:i], segment); keyed != "" {
+			label = keyed
+		} else if a.detectDateParts && isDateParts(segments[i:]) {
+			// A date split by the path separator spans the next three segments
+			segment = strings.Join(segments[i:i+3], a.delimiter)
+			label = labelDate
+			i += 2
+		} else if a.detectPathDate && isPathDate(segments[i:]) {
+			// Archive paths split the date into year, month and day directories
+			segment = strings.Join(segments[i:i+3], a.delimiter)
+			label = labelDate
+			i += 2
+		} else if n := a.timezoneSegments(segments[i:]); n > 0 {
+			// A decoded time zone identifier spans its area and location segments
+			segment = strings.Join(segments[i:i+n], a.delimiter)
+			label = labelTimezone
+			i += n - 1
+		} else if a.compositeRun > 0 && numericRunLength(segments, i) == a.compositeRun {
+			// Composite keys like tile coordinates (/map/12/2048/1024) are grouped as one segment
+			segment = strings.Join(segments[i:i+a.compositeRun], a.delimiter)
+			label = a.compositeLabel
+			i += a.compositeRun - 1
+		} else if stripped, ok := a.stripAssetHash(segment); ok {
+			// The hash-free asset name is stable across builds, so it is kept literal
+			segment = stripped
+		} else {
+			label = a.classify(segment)
+			if label == "" && a.uppercaseBehavior != uppercasePreserve && isUppercase(segment) {
+				if a.uppercaseBehavior == uppercaseLabel {
+					label = labelCode
+				} else {
+					segment = strings.ToLower(segment)
+				}
+			}
+		}
+
+		if label != "" && a.canonicalize[label] {
+			// The ID keeps its identity, only its form is normalized
+			result = append(result, canonicalID(label, segment))
+		} else if label != "" {
+			replaced = append(replaced, replacement{position: len(result), original: segment, label: label})
+			if a.placeholder != "" {
+				label = a.placeholder
+			} else if generic, ok := genericLabel(label); a.genericizeIDs && ok {
+				// The ID scheme is not revealed, only that the segment is an identifier
+				label = generic
+			}
+			// A placeholder already braced (e.g. {id}) is not wrapped again
+			if a.outputFormat == outputFormatDatadog && !(strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}")) {
+				label = "{" + label + "}"
+			}
+			result = append(result, label)
+		} else if !reserved && i == len(segments)-1 && a.aggregateSuffixes[segment] {
+			// Aggregate endpoints stay literal but are tagged for aggregate dashboards
+			result = append(result, a.aggregateLabel+"_"+a.encodeLiteral(segment))
+		} else if !reserved && a.actionVerbs[segment] {
+			// Action verbs stay literal but are tagged so action routes can be told apart
+			result = append(result, labelAction+"_"+a.encodeLiteral(segment))
+		} else {
+			if a.onUnclassified != nil && looksLikeID(segment) && a.unclassifiedSeen.Add(1)%a.unclassifiedRate == 0 {
+				a.onUnclassified(segment)
+			}
+			literals = append(literals, len(result))
+			result = append(result, a.encodeLiteral(segment))
+		}
+	}
+
+	// Nothing left to group (e.g. only empty segments)
+	if len(result) == 0 {
+		return a.emptyPathValue, replaced, 0
+	}
+
+	if a.traversalAfter > 0 {
+		result, replaced, literals = a.collapseTraversal(result, replaced, literals)
+	}
+
+	// Coarse grouping drops the deeper structure, including the IDs found there
+	if a.prefixDepth > 0 && len(result) > a.prefixDepth {
+		result = append(result[:a.prefixDepth], truncationMarker)
+		kept := replaced[:0]
+		for _, r := range replaced {
+			if r.position < a.prefixDepth {
+				kept = append(kept, r)
+			}
+		}
+		replaced = kept
+	}
+
+	risk := 0
+	for _, position := range literals {
+		if position >= riskFreePositions && (a.prefixDepth == 0 || position < a.prefixDepth) {
+			risk++
+		}
+	}
+
+	if a.includeInline {
+		if inline := a.inlineGroup(result, replaced); len(inline) <= a.inlineMaxLength {
+			return inline, replaced, risk
+		}
+	}
+
+	return a.delimiter + strings.Join(result, a.delimiter), replaced, risk
+}
+
+// collapseTraversal shortens runs of literal/ID pairs longer than the configured number of pairs,
+// replacing the pairs past it with a single traversal label. The IDs and literals dropped are no longer reported.
+func (a *AddPathHeader) collapseTraversal(result []string, replaced []replacement, literals []int) ([]string, []replacement, []int) {
+	labeled := make(map[int]bool, len(replaced))
+	for _, r := range replaced {
+		labeled[r.position] = true
+	}
+
+	collapsed := make([]string, 0, len(result))
+	// positions maps each segment to its position in the collapsed group, -1 when dropped
+	positions := make([]int, len(result))
+	for i := 0; i < len(result); {
+		pairs := 0
+		for j := i; j+1 < len(result) && !labeled[j] && labeled[j+1]; j += 2 {
+			pairs++
+		}
+
+		if pairs > a.traversalAfter {
+			for keep := i + 2*a.traversalAfter; i < keep; i++ {
+				positions[i] = len(collapsed)
+				collapsed = append(collapsed, result[i])
+			}
+			collapsed = append(collapsed, labelTraversal)
+			for end := i + 2*(pairs-a.traversalAfter); i < end; i++ {
+				positions[i] = -1
+			}
+			continue
+		}
+
+		positions[i] = len(collapsed)
+		collapsed = append(collapsed, result[i])
+		i++
+	}
+
+	kept := replaced[:0]
+	for _, r := range replaced {
+		if position := positions[r.position]; position >= 0 {
+			r.position = position
+			kept = append(kept, r)
+		}
+	}
+
+	keptLiterals := literals[:0]
+	for _, literal := range literals {
+		if position := positions[literal]; position >= 0 {
+			keptLiterals = append(keptLiterals, position)
+		}
+	}
+	return collapsed, kept, keptLiterals
+}
+
+// inlineGroup renders the path group with each replaced segment followed by its original value.
+// Redacted card numbers keep their label only.
+func (a *AddPathHeader) inlineGroup(result []string, replaced []replacement) string {
+	inline := make([]string, len(result))
+	copy(inline, result)
+	for _, r := range replaced {
+		if a.redacted(r) {
+			continue
+		}
+		inline[r.position] += a.inlineSeparator + r.original
+	}
+	return a.delimiter + strings.Join(inline, a.delimiter)
+}
+
+// formatAudit renders replaced segments as position=value pairs, with values query-escaped.
+// Redacted card numbers are never included.
+func (a *AddPathHeader) formatAudit(replaced []replacement) string {
+	pairs := make([]string, 0, len(replaced))
+	for _, r := range replaced {
+		if a.redacted(r) {
+			continue
+		}
+		pairs = append(pairs, strconv.Itoa(r.position)+"="+url.QueryEscape(r.original))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// destinationGroup groups the path of a WebDAV Destination header URL.
+// Returns false when the header is absent or not a valid URL.
+func (a *AddPathHeader) destinationGroup(destination string) (string, bool) {
+	if destination == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", false
+	}
+
+	path := u.Path
+	if a.detectEmbeddedURL {
+		path = u.EscapedPath()
+	}
+
+	pathGroup, _, _, ok := a.safeExtractPathGroup(path)
+	return pathGroup, ok
+}
+
+// safeExtractPathGroup runs extractPathGroup, recovering from any panic raised during classification
+func (a *AddPathHeader) safeExtractPathGroup(path string) (pathGroup string, replaced []replacement, risk int, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Printf("%s: path group classification for %q panicked: %v", a.name, a.logPath(path), r)
+			pathGroup, replaced, risk, ok = "", nil, 0, false
+		}
+	}()
+
+	pathGroup, replaced, risk = a.extractPathGroup(path)
+	return pathGroup, replaced, risk, true
+}
+
+// extractFragmentGroup normalizes the path-like content of a URL fragment (e.g. client-side routes like #/users/42).
+// A leading delimiter is only kept when the fragment had one.
+func (a *AddPathHeader) extractFragmentGroup(fragment string) (string, bool) {
+	fragmentGroup, _, _, ok := a.safeExtractPathGroup(fragment)
+	if !ok {
+		return "", false
+	}
+	if !strings.HasPrefix(fragment, a.delimiter) {
+		fragmentGroup = strings.TrimPrefix(fragmentGroup, a.delimiter)
+	}
+	return fragmentGroup, true
+}
+
+func (a *AddPathHeader) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if override := a.forHost(req.Host); override != nil {
+		override.ServeHTTP(rw, req)
+		return
+	}
+
+	if len(a.methods) > 0 && !a.methods[req.Method] {
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if a.blockTraversal && hasTraversal(a.sourcePath(req)) {
+		rw.WriteHeader(a.blockStatusCode)
+		return
+	}
+
+	// Double slashes may hide a routing bug
+	if a.emptySegments == emptySegmentReject && strings.Contains(strings.Trim(a.sourcePath(req), a.delimiter), a.delimiter+a.delimiter) {
+		rw.WriteHeader(a.blockStatusCode)
+		return
+	}
+
+	// Monitoring probes often HEAD many unique URLs
+	if req.Method == http.MethodHead && req.URL.RawQuery == "" {
+		switch a.headBehavior {
+		case headBehaviorSkip:
+			a.next.ServeHTTP(rw, req)
+			return
+		case headBehaviorCollapse:
+			req.Header.Set(a.groupHeaderName(req), headProbeGroup)
+			a.next.ServeHTTP(rw, req)
+			return
+		}
+	}
+
+	if a.lazy {
+		lazy := &lazyPathGroup{middleware: a, req: req}
+		a.next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), pathGroupContextKey{}, lazy)))
+		return
+	}
+
+	pathGroup, replaced, risk, ok := a.classifyRequest(req)
+	if !ok {
+		if !a.failOpen {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if a.blockThresholdIDs > 0 && len(replaced) > a.blockThresholdIDs {
+		rw.WriteHeader(a.blockStatusCode)
+		return
+	}
+
+	a.setHeaders(req, pathGroup, replaced, risk)
+
+	if !a.useTrailer {
+		a.next.ServeHTTP(rw, req)
+		return
+	}
+
+	// The trailer must be declared before next writes the status line,
+	// its value can then be set once the body has been written
+	headerName := a.groupHeaderName(req)
+	rw.Header().Add("Trailer", headerName)
+	tw := &trailerWriter{ResponseWriter: rw}
+	a.next.ServeHTTP(tw, req)
+
+	// A response not written yet goes out after this returns, the value would be sent as a header
+	if tw.written {
+		rw.Header().Set(headerName, pathGroup)
+	}
+}
+
+// trailerWriter records whether the upstream handler wrote the response, after which header values are trailers
+type trailerWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trailerWriter) WriteHeader(statusCode int) {
+	// Informational responses do not send the final header
+	if statusCode >= http.StatusOK {
+		w.written = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *trailerWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far, streaming handlers rely on it
+func (w *trailerWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.written = true
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *trailerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// groupHeaderName returns the header configured for the longest prefix of the request path, or the default header
+func (a *AddPathHeader) groupHeaderName(req *http.Request) string {
+	if len(a.prefixHeaders) > 0 {
+		if name, ok := matchPrefix(a.prefixHeaders, a.sourcePath(req)); ok {
+			return name
+		}
+	}
+	return a.headerName
+}
+
+// learn reports a group to the learn sink the first time it is seen
+func (a *AddPathHeader) learn(pathGroup string) {
+	a.learnMu.Lock()
+	if a.learned[pathGroup] || len(a.learned) >= maxLearnedGroups {
+		a.learnMu.Unlock()
+		return
+	}
+	a.learned[pathGroup] = true
+	a.learnMu.Unlock()
+
+	a.learnSink(pathGroup)
+}
+
+// boundGroup returns the group while fewer than MaxDistinctGroups distinct groups have been seen, and the overflow group for new ones after that
+func (a *AddPathHeader) boundGroup(pathGroup string) string {
+	a.distinctMu.Lock()
+	defer a.distinctMu.Unlock()
+
+	if a.distinct[pathGroup] {
+		return pathGroup
+	}
+	if len(a.distinct) < a.maxDistinctGroups {
+		a.distinct[pathGroup] = true
+		return pathGroup
+	}
+
+	if !a.overflowWarned {
+		a.overflowWarned = true
+		a.logger.Printf("%s: %d distinct path groups reached, new groups collapse to %s", a.name, a.maxDistinctGroups, overflowGroup)
+	}
+	return overflowGroup
+}
+
+// sampleExamples reports the original value of each label not reported within the example window.
+// Redacted card numbers are never reported.
+func (a *AddPathHeader) sampleExamples(replaced []replacement) {
+	now := a.now()
+	var due []replacement
+
+	a.exampleMu.Lock()
+	for _, r := range replaced {
+		if a.redacted(r) {
+			continue
+		}
+		if sent, ok := a.exampleSent[r.label]; ok && (a.exampleWindow <= 0 || now.Sub(sent) < a.exampleWindow) {
+			continue
+		}
+		a.exampleSent[r.label] = now
+		due = append(due, r)
+	}
+	a.exampleMu.Unlock()
+
+	for _, r := range due {
+		a.exampleSink(r.label, r.original)
+	}
+}
+
+// schemaMismatch checks a path against the first route schema whose prefix it starts with.
+// Returns the first segment that does not have the expected label (e.g. position=4 expected=uuid got=slug),
+// or an empty string when the path matches or no schema applies.
+func (a *AddPathHeader) schemaMismatch(path string) string {
+	var segments []string
+	for _, segment := range strings.Split(path, a.delimiter) {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	for _, schema := range a.routeSchemas {
+		if len(segments) < len(schema.prefix) || strings.Join(segments[:len(schema.prefix)], a.delimiter) != strings.Join(schema.prefix, a.delimiter) {
+			continue
+		}
+
+		for i, expected := range schema.labels {
+			if expected == "" {
+				continue
+			}
+			position := len(schema.prefix) + i
+			got := "missing"
+			if position < len(segments) {
+				if got = a.classify(segments[position]); got == "" {
+					got = "literal"
+				}
+			}
+			if got != expected {
+				return fmt.Sprintf("position=%d expected=%s got=%s", position, expected, got)
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// cardinalityRisk scores the segments of a route by the number of its literals past the leading positions.
+// Deep literals are where undetected IDs usually hide, so a high score points at a missing detector.
+// Route template placeholders (e.g. {id}) are not literals.
+func cardinalityRisk(segments []string) int {
+	risk := 0
+	for i, segment := range segments {
+		if i >= riskFreePositions && !(strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")) {
+			risk++
+		}
+	}
+	return risk
+}
+
+// sourceURL returns the URL to group, taken from the first source header holding a valid URL
+// or from the request URL. Header values are request URIs whose fragment is split off on the first #
+// and query on the first ?, so query delimiters inside the query itself are kept in it.
+func (a *AddPathHeader) sourceURL(req *http.Request) *url.URL {
+	for _, name := range a.sourceHeaders {
+		if value := req.Header.Get(name); value != "" {
+			value, fragment, _ := strings.Cut(value, "#")
+			path, query, _ := strings.Cut(value, "?")
+			if parsed, err := url.Parse(path); err == nil {
+				parsed.RawQuery = query
+				parsed.Fragment = fragment
+				if unescaped, err := url.PathUnescape(fragment); err == nil {
+					parsed.Fragment = unescaped
+				}
+				return parsed
+			}
+		}
+	}
+	return req.URL
+}
+
+// sourcePath returns the path to group from the source URL. Query strings are dropped.
+func (a *AddPathHeader) sourcePath(req *http.Request) string {
+	u := a.sourceURL(req)
+	if a.detectEmbeddedURL {
+		return u.EscapedPath()
+	}
+	return u.Path
+}
+
+// classifyRequest computes the path group of a request in its configured output format.
+// The cardinality risk returned is the one of the classified segments, before the group is bounded or decorated.
+func (a *AddPathHeader) classifyRequest(req *http.Request) (string, []replacement, int, bool) {
+	path := a.sourcePath(req)
+
+	start := a.now()
+	pathGroup, replaced, risk, ok := a.safeExtractPathGroup(path)
+	if !ok {
+		return "", nil, 0, false
+	}
+
+	if elapsed := a.now().Sub(start); a.slowLogThreshold > 0 && elapsed > a.slowLogThreshold {
+		a.logger.Printf("%s: slow path group classification for %q took %s", a.name, a.logPath(path), elapsed)
+	}
+
+	if a.exampleSink != nil {
+		a.sampleExamples(replaced)
+	}
+
+	// Cardinality stays bounded to the known routes plus the unmatched value
+	if len(a.knownRoutes) > 0 && !a.knownRoutes[pathGroup] {
+		pathGroup = a.unmatchedValue
+	}
+
+	if a.maxDistinctGroups > 0 {
+		pathGroup = a.boundGroup(pathGroup)
+	}
+
+	// The request body type tells apart routes whose path is opaque (e.g. uploads)
+	if len(a.contentTypeGroups) > 0 {
+		if group, ok := matchPrefix(a.contentTypeGroups, strings.ToLower(req.Header.Get("Content-Type"))); ok {
+			pathGroup = strings.TrimSuffix(pathGroup, a.delimiter) + a.delimiter + group
+		}
+	}
+
+	// Fragments never reach the server, only URLs carried in source headers have one
+	if fragment := a.sourceURL(req).Fragment; a.includeFragment && fragment != "" {
+		if fragmentGroup, ok := a.extractFragmentGroup(fragment); ok {
+			pathGroup += "#" + fragmentGroup
+		}
+	}
+
+	if a.outputFormat == outputFormatDatadog {
+		pathGroup = req.Method + " " + pathGroup
+	}
+
+	if a.onGroup != nil {
+		a.onGroup(path, pathGroup)
+	}
+
+	if a.learnSink != nil && a.learnRequests.Add(1)%a.learnRate == 0 {
+		a.learn(pathGroup)
+	}
+
+	return pathGroup, replaced, risk, true
+}
+
+// setHeaders sets the path group header and any auxiliary request headers
+func (a *AddPathHeader) setHeaders(req *http.Request, pathGroup string, replaced []replacement, risk int) {
+	req.Header.Set(a.groupHeaderName(req), pathGroup)
+	headers := &headerBudget{header: req.Header, max: a.maxHeaders, written: 1}
+
+	if a.flagTraversal && hasTraversal(a.sourcePath(req)) {
+		headers.set(suspiciousHeaderName, suspiciousTraversal)
+	}
+
+	if a.normalizeDest {
+		if destinationGroup, ok := a.destinationGroup(req.Header.Get("Destination")); ok {
+			headers.set(destinationGroupHeaderName, destinationGroup)
+		}
+	}
+
+	if a.queryStatsHeader != "" {
+		headers.set(a.queryStatsHeader, "params="+strconv.Itoa(queryParamCount(a.sourceURL(req).RawQuery)))
+	}
+
+	if len(a.routeSchemas) > 0 {
+		if mismatch := a.schemaMismatch(a.sourcePath(req)); mismatch != "" {
+			headers.set(schemaMismatchHeaderName, mismatch)
+		}
+	}
+
+	if a.grpcServiceHeader != "" {
+		if match := grpcPathPattern.FindStringSubmatch(a.sourcePath(req)); match != nil {
+			headers.set(a.grpcServiceHeader, match[1])
+		}
+	}
+
+	if a.baggageKey != "" {
+		headers.set("Baggage", mergeBaggage(req.Header.Values("Baggage"), a.baggageKey, pathGroup))
+	}
+
+	if a.riskHeader != "" {
+		headers.set(a.riskHeader, strconv.Itoa(risk))
+	}
+
+	if a.fullTargetHeader != "" {
+		headers.set(a.fullTargetHeader, fullTarget(req, strings.TrimPrefix(pathGroup, req.Method+" ")))
+	}
+
+	if a.includeName {
+		headers.set(middlewareNameHeaderName, a.name)
+	}
+
+	if a.pathMetrics {
+		path := a.sourcePath(req)
+		depth := 0
+		for _, segment := range strings.Split(path, a.delimiter) {
+			if segment != "" {
+				depth++
+			}
+		}
+		headers.set(pathLengthHeaderName, strconv.Itoa(len(path)))
+		headers.set(pathDepthHeaderName, strconv.Itoa(depth))
+	}
+
+	// Raw IDs are only emitted for explicitly configured, sampled requests
+	if a.auditHeaderName != "" && len(replaced) > 0 && a.auditRequests.Add(1)%a.auditSampleRate == 0 {
+		headers.set(a.auditHeaderName, a.formatAudit(replaced))
+	}
+}
+
+// fullTarget prefixes a path group with the request scheme and normalized host, taken from the request URL,
+// the forwarded headers or the Host header. The group is returned alone when the host is unknown.
+func fullTarget(req *http.Request, pathGroup string) string {
+	host := req.URL.Host
+	if host == "" {
+		host = firstForwarded(req.Header.Get("X-Forwarded-Host"))
+	}
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		return pathGroup
+	}
+
+	scheme := req.URL.Scheme
+	if scheme == "" {
+		scheme = firstForwarded(req.Header.Get("X-Forwarded-Proto"))
+	}
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	return strings.ToLower(scheme) + "://" + normalizeHost(host) + pathGroup
+}
+
+// firstForwarded returns the first element of a forwarded header value, the one set by the proxy closest to the client.
+// Each proxy in the chain appends its own element (e.g. a.com, b.com).
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// mergeBaggage adds a key=value entry to W3C baggage header values, replacing any existing entry for the key.
+// The value is percent-encoded as RFC 3986 requires, so spaces become %20 rather than +.
+func mergeBaggage(values []string, key, value string) string {
+	var entries []string
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if name, _, _ := strings.Cut(entry, "="); strings.TrimSpace(name) == key {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(append(entries, key+"="+url.PathEscape(value)), ",")
+}
+
+// headerBudget caps the number of headers written on a request (0 = unlimited).
+// The path group header is always written and counts towards the cap.
+type headerBudget struct {
+	header  http.Header
+	max     int
+	written int
+}
+
+// set writes an auxiliary header unless the cap has been reached
+func (b *headerBudget) set(name, value string) {
+	if b.max > 0 && b.written >= b.max {
+		return
+	}
+	b.header.Set(name, value)
+	b.written++
+}
+
+// pathGroupContextKey is the request context key under which lazy middlewares store the path group
+type pathGroupContextKey struct{}
+
+// lazyPathGroup computes the path group of a request the first time it is requested
+type lazyPathGroup struct {
+	middleware *AddPathHeader
+	req        *http.Request
+	once       sync.Once
+	pathGroup  string
+	ok         bool
+}
+
+func (l *lazyPathGroup) get() (string, bool) {
+	l.once.Do(func() {
+		var replaced []replacement
+		var risk int
+		l.pathGroup, replaced, risk, l.ok = l.middleware.classifyRequest(l.req)
+		if l.ok {
+			l.middleware.setHeaders(l.req, l.pathGroup, replaced, risk)
+		}
+	})
+	return l.pathGroup, l.ok
+}
+
+// PathGroupFromContext returns the path group of the request owning ctx when the middleware runs with Lazy enabled.
+// The group is computed, and its header set, on the first call only.
+func PathGroupFromContext(ctx context.Context) (string, bool) {
+	lazy, ok := ctx.Value(pathGroupContextKey{}).(*lazyPathGroup)
+	if !ok {
+		return "", false
+	}
+	return lazy.get()
 }
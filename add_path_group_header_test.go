@@ -2,9 +2,13 @@ package traefik_add_path_group_middleware
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddPathHeader_SetsConfiguredHeader(t *testing.T) {
@@ -393,3 +397,6058 @@ func TestAddPathHeader_ExtractsPathGroup(t *testing.T) {
 		})
 	}
 }
+
+func TestAddPathHeader_BlockThresholdIDs(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectNext     bool
+	}{
+		{
+			name:           "Under threshold is forwarded",
+			path:           "/api/v1/courts/42/bookings/7",
+			expectedStatus: http.StatusOK,
+			expectNext:     true,
+		},
+		{
+			name:           "Over threshold is blocked",
+			path:           "/api/v1/123/456/789",
+			expectedStatus: http.StatusBadRequest,
+			expectNext:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.BlockThresholdIDs = 2
+
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if called != tt.expectNext {
+				t.Errorf("expected next called to be %v, got %v", tt.expectNext, called)
+			}
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rw.Code)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_BlockStatusCode(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.BlockThresholdIDs = 1
+	cfg.BlockStatusCode = http.StatusForbidden
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("expected next not to be called")
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/courts/42/bookings/7", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rw.Code)
+	}
+}
+
+func TestAddPathHeader_GenericPlaceholder(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "All ID types collapse to placeholder",
+			path:     "/api/v1/123/550e8400-e29b-41d4-a716-446655440000/01ARZ3NDEKTSV4RRFFQ69G5FAV/clh3am1g30000udocl363eofy/tz4a98xxat96iws9zmbrgj3a/V1StGXR8_Z5jdHi6B-myT",
+			expected: "/api/v1/{id}/{id}/{id}/{id}/{id}/{id}",
+		},
+		{
+			name:     "Dates, files and slugs collapse to placeholder",
+			path:     "/v1/matches/2026-02-26/booking-abc-99/index.html",
+			expected: "/v1/matches/{id}/{id}/{id}",
+		},
+		{
+			name:     "Literals preserved",
+			path:     "/api/v1/users/profile",
+			expected: "/api/v1/users/profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.GenericPlaceholder = "{id}"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectBool(t *testing.T) {
+	tests := []struct {
+		name       string
+		detectBool bool
+		path       string
+		expected   string
+	}{
+		{
+			name:       "Lowercase true labeled",
+			detectBool: true,
+			path:       "/features/true/enabled",
+			expected:   "/features/bool/enabled",
+		},
+		{
+			name:       "Uppercase FALSE labeled",
+			detectBool: true,
+			path:       "/features/FALSE/enabled",
+			expected:   "/features/bool/enabled",
+		},
+		{
+			name:       "Word containing a boolean not labeled",
+			detectBool: true,
+			path:       "/features/truest/enabled",
+			expected:   "/features/truest/enabled",
+		},
+		{
+			name:       "Disabled by default",
+			detectBool: false,
+			path:       "/features/true/enabled",
+			expected:   "/features/true/enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectBool = tt.detectBool
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_HandleWellKnown(t *testing.T) {
+	tests := []struct {
+		name            string
+		handleWellKnown bool
+		path            string
+		expected        string
+	}{
+		{
+			name:            "ACME challenge token grouped",
+			handleWellKnown: true,
+			path:            "/.well-known/acme-challenge/LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0",
+			expected:        "/.well-known/acme-challenge/token",
+		},
+		{
+			name:            "OpenID discovery preserved",
+			handleWellKnown: true,
+			path:            "/.well-known/openid-configuration",
+			expected:        "/.well-known/openid-configuration",
+		},
+		{
+			name:            "Well-known file names preserved",
+			handleWellKnown: true,
+			path:            "/.well-known/security.txt",
+			expected:        "/.well-known/security.txt",
+		},
+		{
+			name:            "Disabled classifies as usual",
+			handleWellKnown: false,
+			path:            "/.well-known/acme-challenge/LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0",
+			expected:        "/.well-known/acme-challenge/slug",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.HandleWellKnown = tt.handleWellKnown
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestExtractPathGroup_SegmentDelimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		path      string
+		expected  string
+	}{
+		{
+			name:      "Pipe-delimited source",
+			delimiter: "|",
+			path:      "api|users|550e8400-e29b-41d4-a716-446655440000|bookings|42",
+			expected:  "|api|users|uuid|bookings|numeric_id",
+		},
+		{
+			name:      "Pipe delimiter keeps slashes inside segments",
+			delimiter: "|",
+			path:      "|api/v1|courts|42",
+			expected:  "|api/v1|courts|numeric_id",
+		},
+		{
+			name:      "Default slash delimiter",
+			delimiter: "",
+			path:      "/api/v1/courts/42/bookings",
+			expected:  "/api/v1/courts/numeric_id/bookings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.SegmentDelimiter = tt.delimiter
+
+			handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			got, _, _ := handler.(*AddPathHeader).extractPathGroup(tt.path)
+			if got != tt.expected {
+				t.Errorf("expected path group %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_LenientUUID(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Standard UUID",
+			path:     "/api/v1/users/550e8400-e29b-41d4-a716-446655440000/profile",
+			expected: "/api/v1/users/uuid/profile",
+		},
+		{
+			name:     "UUID with non-standard dash groupings",
+			path:     "/api/v1/users/550e8400e29b-41d4-a716446655440000/profile",
+			expected: "/api/v1/users/uuid/profile",
+		},
+		{
+			name:     "31 hex digits is not a UUID",
+			path:     "/api/v1/users/550e8400e29b-41d4-a71644665544000/profile",
+			expected: "/api/v1/users/slug/profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LenientUUID = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_PreserveFileNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "index.html preserved",
+			path:     "/app/index.html",
+			expected: "/app/index.html",
+		},
+		{
+			name:     "favicon.ico preserved",
+			path:     "/favicon.ico",
+			expected: "/favicon.ico",
+		},
+		{
+			name:     "Other files collapsed",
+			path:     "/app/assets/logo-abc123.png",
+			expected: "/app/assets/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PreserveFileNames = []string{"index.html", "favicon.ico", "robots.txt"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_UseTrailer(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.UseTrailer = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("streamed body"))
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/courts/42/bookings", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	res := rw.Result()
+	if got := res.Header.Get("x-path-group"); got != "" {
+		t.Errorf("expected no x-path-group response header, got %q", got)
+	}
+	if got := res.Trailer.Get("x-path-group"); got != "/api/v1/courts/numeric_id/bookings" {
+		t.Errorf("expected trailer x-path-group to be /api/v1/courts/numeric_id/bookings, got %q", got)
+	}
+}
+
+func TestAddPathHeader_UseTrailerWithoutBody(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.UseTrailer = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/api/v1/courts/42/bookings")
+	if err != nil {
+		t.Fatalf("unexpected error sending request: %v", err)
+	}
+	defer res.Body.Close()
+	if _, err := io.ReadAll(res.Body); err != nil {
+		t.Fatalf("unexpected error reading body: %v", err)
+	}
+
+	if got := res.Header.Get("x-path-group"); got != "" {
+		t.Errorf("expected no x-path-group response header, got %q", got)
+	}
+}
+
+func TestAddPathHeader_DetectDateParts(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "MM/DD/YYYY collapses to date",
+			path:     "/events/02/26/2026/details",
+			expected: "/events/date/details",
+		},
+		{
+			name:     "DD/MM/YYYY collapses to date",
+			path:     "/events/26/02/2026",
+			expected: "/events/date",
+		},
+		{
+			name:     "Implausible month and day stay separate",
+			path:     "/events/42/13/2026/details",
+			expected: "/events/numeric_id/numeric_id/numeric_id/details",
+		},
+		{
+			name:     "Unrelated numerics stay separate",
+			path:     "/api/v1/123/456/789",
+			expected: "/api/v1/numeric_id/numeric_id/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectDateParts = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_KeepFormatSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Numeric ID with json suffix",
+			path:     "/users/42.json",
+			expected: "/users/numeric_id.json",
+		},
+		{
+			name:     "UUID with xml suffix",
+			path:     "/users/550e8400-e29b-41d4-a716-446655440000.xml",
+			expected: "/users/uuid.xml",
+		},
+		{
+			name:     "Unlisted extension stays file",
+			path:     "/static/logo.png",
+			expected: "/static/file",
+		},
+		{
+			name:     "Listed extension on a literal stays file",
+			path:     "/report.xml",
+			expected: "/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.KeepFormatSuffix = []string{"json", "xml", "csv"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+type fakeLogger struct {
+	entries []string
+}
+
+func (l *fakeLogger) Printf(format string, v ...any) {
+	l.entries = append(l.entries, fmt.Sprintf(format, v...))
+}
+
+func TestAddPathHeader_SlowLogThreshold(t *testing.T) {
+	logger := &fakeLogger{}
+	cfg := CreateConfig()
+	cfg.SlowLogThresholdMs = 5
+	cfg.Logger = logger
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	// Every clock reading advances 10ms, forcing the classification over the threshold
+	clock := time.Now()
+	handler.(*AddPathHeader).now = func() time.Time {
+		clock = clock.Add(10 * time.Millisecond)
+		return clock
+	}
+
+	path := "/api" + strings.Repeat("/550e8400-e29b-41d4-a716-446655440000", 100)
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if len(logger.entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(logger.entries))
+	}
+	if !strings.Contains(logger.entries[0], path) || !strings.Contains(logger.entries[0], "10ms") {
+		t.Errorf("expected log entry to contain the path and duration, got %q", logger.entries[0])
+	}
+}
+
+func TestAddPathHeader_SlowLogDisabledByDefault(t *testing.T) {
+	logger := &fakeLogger{}
+	cfg := CreateConfig()
+	cfg.Logger = logger
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	clock := time.Now()
+	handler.(*AddPathHeader).now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if len(logger.entries) != 0 {
+		t.Errorf("expected no log entries, got %v", logger.entries)
+	}
+}
+
+func TestAddPathHeader_AuditSampling(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.AuditHeaderName = "X-Path-Audit"
+	cfg.AuditSampleRate = 2
+
+	var audits []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		audits = append(audits, req.Header.Get("X-Path-Audit"))
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/550e8400-e29b-41d4-a716-446655440000/courts/42", nil)
+		rw := httptest.NewRecorder()
+
+		handler.ServeHTTP(rw, req)
+	}
+
+	if audits[0] != "" {
+		t.Errorf("expected unsampled request to have no audit header, got %q", audits[0])
+	}
+	if expected := "3=550e8400-e29b-41d4-a716-446655440000,5=42"; audits[1] != expected {
+		t.Errorf("expected sampled audit header %q, got %q", expected, audits[1])
+	}
+}
+
+func TestAddPathHeader_AuditDisabledByDefault(t *testing.T) {
+	cfg := CreateConfig()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		for name := range req.Header {
+			if name != "X-Path-Group" {
+				t.Errorf("expected only the path group header, got %q", name)
+			}
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+}
+
+func TestAddPathHeader_DetectEmbeddedURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Encoded https URL segment",
+			path:     "/redirect/https%3A%2F%2Fexample.com%2Fpath%2F42",
+			expected: "/redirect/url",
+		},
+		{
+			name:     "Encoded http URL segment followed by a literal",
+			path:     "/redirect/http%3A%2F%2Fexample.com/confirm",
+			expected: "/redirect/url/confirm",
+		},
+		{
+			name:     "Normal segments",
+			path:     "/redirect/home/42",
+			expected: "/redirect/home/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectEmbeddedURL = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_RecoversFromClassificationPanic(t *testing.T) {
+	tests := []struct {
+		name           string
+		failOpen       bool
+		expectNext     bool
+		expectedStatus int
+	}{
+		{
+			name:           "Fail open forwards without header",
+			failOpen:       true,
+			expectNext:     true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Fail closed responds 500",
+			failOpen:       false,
+			expectNext:     false,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.FailOpen = tt.failOpen
+			cfg.Logger = &fakeLogger{}
+
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+				if got := req.Header.Get("x-path-group"); got != "" {
+					t.Errorf("expected no x-path-group header, got %q", got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+			handler.(*AddPathHeader).classify = func(segment string) string {
+				panic("broken classifier")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if called != tt.expectNext {
+				t.Errorf("expected next called to be %v, got %v", tt.expectNext, called)
+			}
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rw.Code)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_TypedPrefixMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Stripe customer ID",
+			path:     "/v1/customers/cus_Nv8f2h8/charges",
+			expected: "/v1/customers/cus_id/charges",
+		},
+		{
+			name:     "Stripe charge ID",
+			path:     "/v1/charges/ch_3LxZ8q2eZvKYlo2C",
+			expected: "/v1/charges/ch_id",
+		},
+		{
+			name:     "Twilio account SID",
+			path:     "/Accounts/AC0123456789abcdef0123456789abcdef/Messages",
+			expected: "/Accounts/AC_id/Messages",
+		},
+		{
+			name:     "Short numeric body stays slug",
+			path:     "/api/v1/users/user_42/profile",
+			expected: "/api/v1/users/slug/profile",
+		},
+		{
+			name:     "Prefixed UUID keeps its UUID label",
+			path:     "/api/v1/users/usr_550e8400-e29b-41d4-a716-446655440000/profile",
+			expected: "/api/v1/users/uuid/profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.TypedPrefixMode = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_Methods(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		expected string
+	}{
+		{
+			name:     "Configured method is classified",
+			method:   http.MethodGet,
+			expected: "/api/v1/users/numeric_id",
+		},
+		{
+			name:     "Other method passes through",
+			method:   http.MethodPost,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.Methods = []string{"get"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(tt.method, "/api/v1/users/42", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DateAwareFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepExtension bool
+		path          string
+		expected      string
+	}{
+		{
+			name:          "Timestamped log with dashes in time",
+			keepExtension: true,
+			path:          "/logs/2024-02-26T00-01-55.log",
+			expected:      "/logs/date.log",
+		},
+		{
+			name:          "Dated archive",
+			keepExtension: true,
+			path:          "/exports/2024-02-26.csv",
+			expected:      "/exports/date.csv",
+		},
+		{
+			name:          "Timestamped log labeled iso_date",
+			keepExtension: false,
+			path:          "/logs/2024-02-26T00-01-55.log",
+			expected:      "/logs/iso_date",
+		},
+		{
+			name:          "Normal log file",
+			keepExtension: true,
+			path:          "/logs/app.log",
+			expected:      "/logs/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DateAwareFiles = true
+			cfg.DateFileKeepExtension = tt.keepExtension
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DatadogOutputFormat(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.OutputFormat = "datadog"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got := req.Header.Get("x-path-group")
+		expected := "POST /api/v1/tenants/{uuid}/courts/{numeric_id}/bookings/{slug}"
+		if got != expected {
+			t.Errorf("expected path group %q, got %q", expected, got)
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tenants/550e8400-e29b-41d4-a716-446655440000/courts/42/bookings/booking-abc-99", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+}
+
+func TestAddPathHeader_DatadogBracedPlaceholder(t *testing.T) {
+	for _, placeholder := range []string{"{id}", "id"} {
+		cfg := CreateConfig()
+		cfg.OutputFormat = "datadog"
+		cfg.GenericPlaceholder = placeholder
+
+		expected := "GET /api/v1/users/{id}"
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if got := req.Header.Get("x-path-group"); got != expected {
+				t.Errorf("expected path group %q with placeholder %q, got %q", expected, placeholder, got)
+			}
+		})
+
+		handler, err := New(context.Background(), next, cfg, "test-middleware")
+		if err != nil {
+			t.Fatalf("unexpected error creating middleware: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestNew_UnknownOutputFormat(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.OutputFormat = "prometheus"
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error for unknown output format")
+	}
+}
+
+func TestNew_InvalidBlockStatusCode(t *testing.T) {
+	for _, code := range []int{42, http.StatusOK, http.StatusFound, 600} {
+		cfg := CreateConfig()
+		cfg.BlockStatusCode = code
+
+		if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+			t.Errorf("expected error for block status code %d", code)
+		}
+	}
+}
+
+func TestAddPathHeader_LocaleAwareResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Leading locale collapsed",
+			path:     "/en-US/users/42",
+			expected: "/locale/users/numeric_id",
+		},
+		{
+			name:     "Underscore locale collapsed",
+			path:     "/pt_BR/users/42",
+			expected: "/locale/users/numeric_id",
+		},
+		{
+			name:     "Path without locale",
+			path:     "/users/42",
+			expected: "/users/numeric_id",
+		},
+		{
+			name:     "Locale-like segment not in first position preserved",
+			path:     "/users/en-US",
+			expected: "/users/en-US",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LocaleAwareResource = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectEtag(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Strong etag",
+			path:     `/objects/abc/versions/"33a64df551"`,
+			expected: "/objects/abc/versions/etag",
+		},
+		{
+			name:     "Weak etag",
+			path:     `/objects/abc/versions/W/"33a64df551"/content`,
+			expected: "/objects/abc/versions/etag/content",
+		},
+		{
+			name:     "Quoted word is not an etag",
+			path:     `/objects/abc/versions/"latest"`,
+			expected: `/objects/abc/versions/"latest"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectEtag = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.path
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_EmptyPathValue(t *testing.T) {
+	tests := []struct {
+		name           string
+		emptyPathValue string
+		path           string
+		expected       string
+	}{
+		{
+			name:     "Path with only empty segments",
+			path:     "///",
+			expected: "/",
+		},
+		{
+			name:           "Path with only empty segments uses configured value",
+			emptyPathValue: "(root)",
+			path:           "///",
+			expected:       "(root)",
+		},
+		{
+			name:     "Single ID path keeps leading slash",
+			path:     "/550e8400-e29b-41d4-a716-446655440000",
+			expected: "/uuid",
+		},
+		{
+			name:     "Root path",
+			path:     "/",
+			expected: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.EmptyPathValue = tt.emptyPathValue
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectIMEI(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Valid IMEI",
+			path:     "/devices/490154203237518/info",
+			expected: "/devices/imei/info",
+		},
+		{
+			name:     "15-digit number failing Luhn",
+			path:     "/devices/490154203237519/info",
+			expected: "/devices/numeric_id/info",
+		},
+		{
+			name:     "14-digit number",
+			path:     "/devices/49015420323752/info",
+			expected: "/devices/numeric_id/info",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectIMEI = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_RedactCardNumbers(t *testing.T) {
+	tests := []struct {
+		name           string
+		redactionLabel string
+		path           string
+		expected       string
+	}{
+		{
+			name:     "Luhn-valid 16-digit PAN redacted",
+			path:     "/payments/4111111111111111/status",
+			expected: "/payments/redacted/status",
+		},
+		{
+			name:           "Custom redaction label",
+			redactionLabel: "pan",
+			path:           "/payments/4111111111111111/status",
+			expected:       "/payments/pan/status",
+		},
+		{
+			name:     "Random 16-digit number stays numeric",
+			path:     "/payments/4111111111111112/status",
+			expected: "/payments/numeric_id/status",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			if tt.redactionLabel != "" {
+				cfg.RedactionLabel = tt.redactionLabel
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_RedactedCardNumbersExcludedFromAudit(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.AuditHeaderName = "X-Path-Audit"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got := req.Header.Get("X-Path-Audit")
+		if got != "3=42" {
+			t.Errorf("expected audit header %q, got %q", "3=42", got)
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/4111111111111111/users/42", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+}
+
+func TestAddPathHeader_FileClasses(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Image file",
+			path:     "/static/img/logo.png",
+			expected: "/static/img/file_image",
+		},
+		{
+			name:     "Script file with uppercase extension",
+			path:     "/static/js/app.min.JS",
+			expected: "/static/js/file_script",
+		},
+		{
+			name:     "Unmapped extension",
+			path:     "/static/docs/report.pdf",
+			expected: "/static/docs/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.FileClasses = map[string][]string{
+				"image":  {"png", "jpg", "gif"},
+				"script": {"js", "mjs"},
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_HeadRequestBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior string
+		target   string
+		expected string
+	}{
+		{
+			name:     "Classify",
+			behavior: "classify",
+			target:   "/api/v1/users/42",
+			expected: "/api/v1/users/numeric_id",
+		},
+		{
+			name:     "Skip",
+			behavior: "skip",
+			target:   "/api/v1/users/42",
+			expected: "",
+		},
+		{
+			name:     "Collapse",
+			behavior: "collapse",
+			target:   "/api/v1/users/42",
+			expected: "/head-probe",
+		},
+		{
+			name:     "HEAD with query is classified",
+			behavior: "collapse",
+			target:   "/api/v1/users/42?full=true",
+			expected: "/api/v1/users/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.HeadRequestBehavior = tt.behavior
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodHead, tt.target, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestNew_UnknownHeadRequestBehavior(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.HeadRequestBehavior = "ignore"
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error for unknown HEAD request behavior")
+	}
+}
+
+func TestAddPathHeader_PaginationKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Page number",
+			path:     "/items/page/2",
+			expected: "/items/page/page_num",
+		},
+		{
+			name:     "Page number and size",
+			path:     "/items/page/2/size/50",
+			expected: "/items/page/page_num/size/page_size",
+		},
+		{
+			name:     "Non-numeric value after key",
+			path:     "/docs/page/intro",
+			expected: "/docs/page/intro",
+		},
+		{
+			name:     "Numeric value after other key",
+			path:     "/items/42/page/3",
+			expected: "/items/numeric_id/page/page_num",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PaginationKeys = map[string]string{
+				"page": "page_num",
+				"size": "page_size",
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_PrefixLabelMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Mapped prefix with UUID",
+			path:     "/api/v1/users/user_550e8400-e29b-41d4-a716-446655440000",
+			expected: "/api/v1/users/user_uuid",
+		},
+		{
+			name:     "Mapped prefix with colon and numeric ID",
+			path:     "/api/v1/courts/court:12345/bookings",
+			expected: "/api/v1/courts/court_numeric_id/bookings",
+		},
+		{
+			name:     "Unmapped prefix",
+			path:     "/api/v1/users/usr_550e8400-e29b-41d4-a716-446655440000",
+			expected: "/api/v1/users/uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PrefixLabelMap = map[string]string{
+				"user":  "user",
+				"court": "court",
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_IncludeFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		expected string
+	}{
+		{
+			name:     "Fragment route with an ID",
+			fragment: "/users/42/profile",
+			expected: "/app/numeric_id#/users/numeric_id/profile",
+		},
+		{
+			name:     "Fragment without a leading slash",
+			fragment: "section-2",
+			expected: "/app/numeric_id#slug",
+		},
+		{
+			name:     "No fragment",
+			fragment: "",
+			expected: "/app/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.IncludeFragment = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/app/7", nil)
+			req.URL.Fragment = tt.fragment
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ActionVerbs(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Action verb tagged",
+			path:     "/users/42/activate",
+			expected: "/users/numeric_id/action_activate",
+		},
+		{
+			name:     "Other action verb tagged",
+			path:     "/orders/7/cancel",
+			expected: "/orders/numeric_id/action_cancel",
+		},
+		{
+			name:     "Non-verb literal left plain",
+			path:     "/users/42/profile",
+			expected: "/users/numeric_id/profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ActionVerbs = []string{"activate", "cancel"}
+			cfg.BlockThresholdIDs = 1
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Errorf("expected action verbs not to count as IDs, got status %d", rw.Code)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_LazySkipsClassificationWhenRejected(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.Lazy = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	a := handler.(*AddPathHeader)
+	calls := 0
+	a.classify = func(segment string) string {
+		calls++
+		return a.identifyIDType(segment)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if calls != 0 {
+		t.Errorf("expected no classification, got %d classifier calls", calls)
+	}
+	if got := req.Header.Get("x-path-group"); got != "" {
+		t.Errorf("expected no x-path-group header, got %q", got)
+	}
+}
+
+func TestAddPathHeader_LazyComputesOnDemand(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.Lazy = true
+
+	var first, second string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		first, _ = PathGroupFromContext(req.Context())
+		second, _ = PathGroupFromContext(req.Context())
+		if got := req.Header.Get("x-path-group"); got != "/api/v1/users/numeric_id" {
+			t.Errorf("expected header x-path-group to be /api/v1/users/numeric_id, got %q", got)
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	a := handler.(*AddPathHeader)
+	calls := 0
+	a.classify = func(segment string) string {
+		calls++
+		return a.identifyIDType(segment)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if first != "/api/v1/users/numeric_id" || second != first {
+		t.Errorf("expected path group /api/v1/users/numeric_id twice, got %q and %q", first, second)
+	}
+	if calls != 4 {
+		t.Errorf("expected the path to be classified once (4 segments), got %d classifier calls", calls)
+	}
+}
+
+func TestPathGroupFromContext_NotLazy(t *testing.T) {
+	if _, ok := PathGroupFromContext(context.Background()); ok {
+		t.Error("expected no path group in a context without a lazy middleware")
+	}
+}
+
+func TestNew_LazyWithBlocking(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.Lazy = true
+	cfg.BlockThresholdIDs = 2
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error combining lazy mode with blocking")
+	}
+}
+
+func TestAddPathHeader_ValidateUUIDVariant(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Valid variant",
+			path:     "/api/v1/users/550e8400-e29b-41d4-a716-446655440000",
+			expected: "/api/v1/users/uuid",
+		},
+		{
+			name:     "Invalid variant falls through",
+			path:     "/api/v1/users/550e8400-e29b-41d4-c716-446655440000",
+			expected: "/api/v1/users/slug",
+		},
+		{
+			name:     "Uppercase valid variant",
+			path:     "/api/v1/users/550E8400-E29B-41D4-B716-446655440000",
+			expected: "/api/v1/users/uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ValidateUUIDVariant = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_MaxHeadersPerRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxHeaders  int
+		expectAudit bool
+	}{
+		{
+			name:        "Cap reached by the path group header",
+			maxHeaders:  1,
+			expectAudit: false,
+		},
+		{
+			name:        "Cap leaves room for auxiliary headers",
+			maxHeaders:  2,
+			expectAudit: true,
+		},
+		{
+			name:        "Unlimited",
+			maxHeaders:  0,
+			expectAudit: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.AuditHeaderName = "X-Path-Audit"
+			cfg.MaxHeadersPerRequest = tt.maxHeaders
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != "/api/numeric_id/uuid/ulid/nanoid" {
+					t.Errorf("expected path group header to always be set, got %q", got)
+				}
+				if got := req.Header.Get("X-Path-Audit") != ""; got != tt.expectAudit {
+					t.Errorf("expected audit header present to be %v, got %v", tt.expectAudit, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/123/550e8400-e29b-41d4-a716-446655440000/01ARZ3NDEKTSV4RRFFQ69G5FAV/V1StGXR8_Z5jdHi6B-myT", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectIDLists(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Comma-separated numeric IDs",
+			path:     "/items/1,2,3/fetch",
+			expected: "/items/id_list/fetch",
+		},
+		{
+			name:     "Semicolon-separated UUID and numeric IDs",
+			path:     "/items/550e8400-e29b-41d4-a716-446655440000;42/fetch",
+			expected: "/items/id_list/fetch",
+		},
+		{
+			name:     "List with a literal part left alone",
+			path:     "/items/1,latest/fetch",
+			expected: "/items/1,latest/fetch",
+		},
+		{
+			name:     "Trailing separator left alone",
+			path:     "/items/1,2,/fetch",
+			expected: "/items/1,2,/fetch",
+		},
+		{
+			name:     "Non-list segment",
+			path:     "/items/42/fetch",
+			expected: "/items/numeric_id/fetch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectIDLists = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_OnGroup(t *testing.T) {
+	var groups []string
+	cfg := CreateConfig()
+	cfg.OnGroup = func(path, group string) {
+		groups = append(groups, path+" -> "+group)
+	}
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	for _, path := range []string{"/api/v1/users/42", "/api/v1/users/profile", "/files/report.pdf"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rw := httptest.NewRecorder()
+
+		handler.ServeHTTP(rw, req)
+	}
+
+	expected := []string{
+		"/api/v1/users/42 -> /api/v1/users/numeric_id",
+		"/api/v1/users/profile -> /api/v1/users/profile",
+		"/files/report.pdf -> /files/file",
+	}
+	if strings.Join(groups, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+}
+
+func TestAddPathHeader_LangSegmentPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "English",
+			path:     "/api/v1/docs/en/getting-started",
+			expected: "/api/v1/docs/lang/getting-started",
+		},
+		{
+			name:     "German",
+			path:     "/api/v1/docs/de/getting-started",
+			expected: "/api/v1/docs/lang/getting-started",
+		},
+		{
+			name:     "Portuguese",
+			path:     "/api/v1/docs/pt/getting-started",
+			expected: "/api/v1/docs/lang/getting-started",
+		},
+		{
+			name:     "Non-language word at the position",
+			path:     "/api/v1/docs/faq/getting-started",
+			expected: "/api/v1/docs/faq/getting-started",
+		},
+		{
+			name:     "Language code elsewhere",
+			path:     "/api/v1/it/docs",
+			expected: "/api/v1/it/docs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LangSegmentPosition = 4
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_LangSegmentPositionAfterLocale(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Without locale",
+			path:     "/docs/en/page",
+			expected: "/docs/lang/page",
+		},
+		{
+			name:     "After a collapsed locale",
+			path:     "/en-US/docs/en/page",
+			expected: "/locale/docs/lang/page",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LocaleAwareResource = true
+			cfg.LangSegmentPosition = 2
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestAddPathHeader_CompositeNumericRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Run of three collapses",
+			path:     "/map/12/2048/1024",
+			expected: "/map/tile_coords",
+		},
+		{
+			name:     "Run of three followed by a literal",
+			path:     "/map/12/2048/1024/tile.png",
+			expected: "/map/tile_coords/file",
+		},
+		{
+			name:     "Run of two stays separate",
+			path:     "/map/12/2048",
+			expected: "/map/numeric_id/numeric_id",
+		},
+		{
+			name:     "Run of four stays separate",
+			path:     "/map/1/12/2048/1024",
+			expected: "/map/numeric_id/numeric_id/numeric_id/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CompositeNumericRun = 3
+			cfg.CompositeNumericLabel = "tile_coords"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_CompressionSuffixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Gzipped script",
+			path:     "/static/app.js.gz",
+			expected: "/static/file.js",
+		},
+		{
+			name:     "Brotli stylesheet",
+			path:     "/static/style.css.br",
+			expected: "/static/file.css",
+		},
+		{
+			name:     "Compressed file without inner extension",
+			path:     "/downloads/archive.gz",
+			expected: "/downloads/file",
+		},
+		{
+			name:     "Numeric inner extension dropped",
+			path:     "/logs/app.1708900000.gz",
+			expected: "/logs/file",
+		},
+		{
+			name:     "Uncompressed file",
+			path:     "/static/app.js",
+			expected: "/static/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_HostOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		expected string
+	}{
+		{
+			name:     "Exact host override",
+			host:     "flags.example.com",
+			expected: "/features/bool/numeric_id",
+		},
+		{
+			name:     "Wildcard host override with port",
+			host:     "tenant-a.apps.example.com:8443",
+			expected: "/features/true/{id}",
+		},
+		{
+			name:     "Unmatched host uses base configuration",
+			host:     "other.example.org",
+			expected: "/features/true/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.HostOverrides = map[string]map[string]any{
+				"flags.example.com":  {"detectBool": true},
+				"*.apps.example.com": {"genericPlaceholder": "{id}"},
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/features/true/42", nil)
+			req.Host = tt.host
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestNew_InvalidHostOverride(t *testing.T) {
+	for _, override := range []map[string]any{{"outputFormat": "prometheus"}, {"headerNam": "x-a"}} {
+		cfg := CreateConfig()
+		cfg.HostOverrides = map[string]map[string]any{"api.example.com": override}
+
+		if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+			t.Errorf("expected error for invalid host override %v", override)
+		}
+	}
+}
+
+func TestAddPathHeader_EntropyThreshold(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "High-entropy token",
+			path:     "/share/Xq7~Lp!Zr4~Nk2wV/view",
+			expected: "/share/random/view",
+		},
+		{
+			name:     "High-entropy letters-only token",
+			path:     "/share/kHqWzPeRtYuIoPlM/view",
+			expected: "/share/random/view",
+		},
+		{
+			name:     "Natural word below threshold",
+			path:     "/docs/internationalization/view",
+			expected: "/docs/internationalization/view",
+		},
+		{
+			name:     "Short segment",
+			path:     "/share/kHqWzPeR/view",
+			expected: "/share/kHqWzPeR/view",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.EntropyThreshold = 3.7
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_PreservePrefixCase(t *testing.T) {
+	tests := []struct {
+		name               string
+		preservePrefixCase bool
+		expected           string
+	}{
+		{
+			name:               "Uppercase prefix preserved",
+			preservePrefixCase: true,
+			expected:           "/api/v1/users/USR_uuid",
+		},
+		{
+			name:               "Prefix lowercased by default",
+			preservePrefixCase: false,
+			expected:           "/api/v1/users/usr_uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PrefixLabelMap = map[string]string{"usr": "usr"}
+			cfg.PreservePrefixCase = tt.preservePrefixCase
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/USR_550e8400-e29b-41d4-a716-446655440000", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectPathDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Year month day directories",
+			path:     "/archive/2024/02/26/article",
+			expected: "/archive/date/article",
+		},
+		{
+			name:     "Invalid date stays numeric",
+			path:     "/archive/2024/13/40/article",
+			expected: "/archive/numeric_id/numeric_id/numeric_id/article",
+		},
+		{
+			name:     "Numeric run that is not a date",
+			path:     "/map/12/2048/1024",
+			expected: "/map/numeric_id/numeric_id/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectPathDate = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_IncludeOriginalInline(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		separator       string
+		inlineMaxLength int
+		expected        string
+	}{
+		{
+			name:     "UUID segment emits label and original",
+			path:     "/users/550e8400-e29b-41d4-a716-446655440000/profile",
+			expected: "/users/uuid|550e8400-e29b-41d4-a716-446655440000/profile",
+		},
+		{
+			name:     "Literal segments unchanged",
+			path:     "/api/v1/health",
+			expected: "/api/v1/health",
+		},
+		{
+			name:      "Custom separator",
+			path:      "/orders/42",
+			separator: "=",
+			expected:  "/orders/numeric_id=42",
+		},
+		{
+			name:            "Over the length cap falls back to labels",
+			path:            "/users/550e8400-e29b-41d4-a716-446655440000/profile",
+			inlineMaxLength: 20,
+			expected:        "/users/uuid/profile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.IncludeOriginalInline = true
+			if tt.separator != "" {
+				cfg.InlineSeparator = tt.separator
+			}
+			if tt.inlineMaxLength != 0 {
+				cfg.InlineMaxLength = tt.inlineMaxLength
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_FlagTraversal(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		blockTraversal bool
+		expectedFlag   string
+		expectedStatus int
+	}{
+		{
+			name:           "Traversal path flagged",
+			path:           "/static/../etc/passwd",
+			expectedFlag:   "traversal",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Clean path not flagged",
+			path:           "/static/app.js",
+			expectedFlag:   "",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Traversal path blocked",
+			path:           "/static/../etc/passwd",
+			blockTraversal: true,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.FlagTraversal = true
+			cfg.BlockTraversal = tt.blockTraversal
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("X-Path-Suspicious")
+				if got != tt.expectedFlag {
+					t.Errorf("expected suspicious header %q, got %q", tt.expectedFlag, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rw.Code)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_DetectCursor(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Padded base64 cursor",
+			path:     "/feed/after/eyJpZCI6MTIzfQ==/items",
+			expected: "/feed/after/cursor/items",
+		},
+		{
+			name:     "Unpadded url-safe cursor",
+			path:     "/feed/after/eyJpZCI6MTIzLCJ0IjoxfQ/items",
+			expected: "/feed/after/cursor/items",
+		},
+		{
+			name:     "Non-base64 segment",
+			path:     "/feed/after/latest/items",
+			expected: "/feed/after/latest/items",
+		},
+		{
+			name:     "Lowercase slug stays slug",
+			path:     "/posts/my-post-2024-edition",
+			expected: "/posts/slug",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectCursor = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestConfig_ActiveDetectors(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.RedactCardNumbers = false
+	cfg.CompositeNumericRun = 3
+	cfg.CompositeNumericLabel = "tile"
+
+	detectors := make(map[string]DetectorInfo)
+	for _, d := range cfg.ActiveDetectors() {
+		detectors[d.Name] = d
+	}
+
+	if d := detectors["card_number"]; d.Enabled {
+		t.Errorf("expected card_number detector to be disabled, got %+v", d)
+	}
+	if d := detectors["composite_numeric"]; !d.Enabled || d.Label != "tile" {
+		t.Errorf("expected enabled composite_numeric detector labeled tile, got %+v", d)
+	}
+	if d := detectors["uuid"]; !d.Enabled || d.Label != "uuid" {
+		t.Errorf("expected enabled uuid detector, got %+v", d)
+	}
+
+	cfg.GenericPlaceholder = "id"
+	for _, d := range cfg.ActiveDetectors() {
+		// Empty segments are kept as such with a placeholder
+		if d.Name != "empty" && d.Label != "id" {
+			t.Errorf("expected detector %s to emit the placeholder, got %q", d.Name, d.Label)
+		}
+	}
+}
+
+func TestConfig_ActiveDetectorsLabelSteps(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.KeepFormatSuffix = []string{"json"}
+	cfg.DotPrefixExtraction = true
+	cfg.DateAwareFiles = true
+	cfg.FileClasses = map[string][]string{"image": {"png", "jpg"}}
+	cfg.PrefixLabelMap = map[string]string{"USR": "user"}
+	cfg.ActionVerbs = []string{"cancel"}
+	cfg.AggregateSuffixes = []string{"count"}
+	cfg.EmptySegmentBehavior = emptySegmentPreserve
+
+	expected := map[string]string{
+		"empty":              "empty",
+		"format_suffix":      "<label>.<ext>",
+		"compression_suffix": "file.<ext>",
+		"dot_prefix":         "<label>",
+		"date_file":          "date.<ext>",
+		"file_class_image":   "file_image",
+		"prefix_label_usr":   "user_<label>",
+		"action_cancel":      "action_cancel",
+		"aggregate_count":    "aggregate_count",
+	}
+
+	detectors := make(map[string]DetectorInfo)
+	for _, d := range cfg.ActiveDetectors() {
+		detectors[d.Name] = d
+	}
+	for name, label := range expected {
+		if d, ok := detectors[name]; !ok || !d.Enabled || d.Label != label {
+			t.Errorf("expected enabled %s detector labeled %q, got %+v (present %v)", name, label, d, ok)
+		}
+	}
+
+	// Tags are applied by the path walk, so neither legacy star mode nor the placeholder replaces them
+	cfg.LegacyStarMode = true
+	cfg.GenericPlaceholder = "id"
+	for _, d := range cfg.ActiveDetectors() {
+		if d.Name == "action_cancel" && (!d.Enabled || d.Label != "action_cancel") {
+			t.Errorf("expected the action tag to be kept, got %+v", d)
+		}
+		if d.Name == "file_class_image" && d.Enabled {
+			t.Errorf("expected file classes to be disabled in legacy star mode, got %+v", d)
+		}
+	}
+}
+
+func TestAddPathHeader_DetectPaseto(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "PASETO v2.local token",
+			path:     "/auth/refresh/v2.local.QAxIpVe-ECVNI1z4xQbm_qQYomyT3h8FtV8bxkz8pBJWkT8f7HtlOpbroPDEZUKop_vaglyp76CzYy375cHmKCW8e1CCkV0Lflu4GTDyXMqQdpZMM1E6OaoQW27gaRSvWBrR3IgbFIa0AkuUFw",
+			expected: "/auth/refresh/token",
+		},
+		{
+			name:     "PASETO v4.public token with footer",
+			path:     "/auth/refresh/v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIn0.eyJraWQiOiJrZXkifQ",
+			expected: "/auth/refresh/token",
+		},
+		{
+			name:     "Three-part JWT is not a PASETO token",
+			path:     "/auth/refresh/eyJhbGci.eyJzdWIi.c2lnbmF0dXJl",
+			expected: "/auth/refresh/file",
+		},
+		{
+			name:     "Dotted filename stays file",
+			path:     "/downloads/v2.release.tar",
+			expected: "/downloads/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectPaseto = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_OnUnclassified(t *testing.T) {
+	var segments []string
+
+	cfg := CreateConfig()
+	cfg.OnUnclassified = func(segment string) {
+		segments = append(segments, segment)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/items/ab~12cd34ef/42/details", nil)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, req)
+
+	if len(segments) != 1 || segments[0] != "ab~12cd34ef" {
+		t.Errorf("expected only the suspicious segment to be reported, got %v", segments)
+	}
+}
+
+func TestAddPathHeader_UnclassifiedSampleRate(t *testing.T) {
+	reported := 0
+
+	cfg := CreateConfig()
+	cfg.OnUnclassified = func(segment string) {
+		reported++
+	}
+	cfg.UnclassifiedSampleRate = 2
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/items/ab~12cd34ef", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if reported != 2 {
+		t.Errorf("expected 2 sampled reports, got %d", reported)
+	}
+}
+
+func TestAddPathHeader_NormalizeDestinationHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		expected    string
+	}{
+		{
+			name:        "MOVE with a Destination header",
+			destination: "https://dav.example.com/files/42/report-2024.pdf",
+			expected:    "/files/numeric_id/file",
+		},
+		{
+			name:        "No Destination header",
+			destination: "",
+			expected:    "",
+		},
+		{
+			name:        "Invalid Destination header",
+			destination: "http://[::1",
+			expected:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.NormalizeDestinationHeader = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("X-Destination-Group")
+				if got != tt.expected {
+					t.Errorf("expected destination group %q, got %q", tt.expected, got)
+				}
+				if group := req.Header.Get("x-path-group"); group != "/files/numeric_id/file" {
+					t.Errorf("expected path group %q, got %q", "/files/numeric_id/file", group)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest("MOVE", "/files/41/report-2023.pdf", nil)
+			if tt.destination != "" {
+				req.Header.Set("Destination", tt.destination)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_StructuredID(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		patterns map[string]string
+		expected string
+	}{
+		{
+			name:     "Order number",
+			path:     "/orders/ORD-2024-000123",
+			expected: "/orders/structured_id",
+		},
+		{
+			name:     "Invoice number",
+			path:     "/invoices/INV-2024-0045/pdf",
+			expected: "/invoices/structured_id/pdf",
+		},
+		{
+			name:     "Slug stays slug",
+			path:     "/bookings/booking-abc-99",
+			expected: "/bookings/slug",
+		},
+		{
+			name:     "Custom pattern emits its label",
+			path:     "/tickets/TCK12345",
+			patterns: map[string]string{"ticket_id": `^TCK\d+$`},
+			expected: "/tickets/ticket_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectStructuredID = true
+			cfg.StructuredIDPatterns = tt.patterns
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestNew_InvalidStructuredIDPattern(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.StructuredIDPatterns = map[string]string{"broken": `^(`}
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error for invalid structured ID pattern")
+	}
+}
+
+func TestAddPathHeader_EncodeOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		characters string
+		expected   string
+	}{
+		{
+			name:     "Literal segment with semicolon encoded",
+			path:     "/users/42/matrix;color=red",
+			expected: "/users/numeric_id/matrix%3Bcolor%3Dred",
+		},
+		{
+			name:     "Normal path unchanged",
+			path:     "/api/v1/users/42",
+			expected: "/api/v1/users/numeric_id",
+		},
+		{
+			name:       "Custom character set",
+			path:       "/rooms/lobby:main;east",
+			characters: ":",
+			expected:   "/rooms/lobby%3Amain;east",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.EncodeOutput = true
+			if tt.characters != "" {
+				cfg.EncodeCharacters = tt.characters
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectTimezone(t *testing.T) {
+	tests := []struct {
+		name              string
+		path              string
+		detectEmbeddedURL bool
+		expected          string
+	}{
+		{
+			name:     "Encoded timezone decoded into two segments",
+			path:     "/tz/America%2FNew_York/events",
+			expected: "/tz/timezone/events",
+		},
+		{
+			name:     "Three-part timezone",
+			path:     "/tz/America/Argentina/Buenos_Aires/events",
+			expected: "/tz/timezone/events",
+		},
+		{
+			name:              "Encoded timezone kept as one segment",
+			path:              "/tz/Europe%2FMadrid/events",
+			detectEmbeddedURL: true,
+			expected:          "/tz/timezone/events",
+		},
+		{
+			name:     "Non-timezone path",
+			path:     "/regions/America/stores",
+			expected: "/regions/America/stores",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectTimezone = true
+			cfg.DetectEmbeddedURL = tt.detectEmbeddedURL
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_GroupPrefixDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Deep path truncated",
+			path:     "/api/v1/users/42/orders/7",
+			expected: "/api/v1/...",
+		},
+		{
+			name:     "Leading IDs still classified",
+			path:     "/42/profile/settings",
+			expected: "/numeric_id/profile/...",
+		},
+		{
+			name:     "Path shorter than depth",
+			path:     "/health",
+			expected: "/health",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.GroupPrefixDepth = 2
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DotPrefixExtraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Dotted UUID prefix",
+			path:     "/t.550e8400-e29b-41d4-a716-446655440000/resource",
+			expected: "/uuid/resource",
+		},
+		{
+			name:     "Dotted ULID prefix",
+			path:     "/t.01ARZ3NDEKTSV4RRFFQ69G5FAV/resource",
+			expected: "/ulid/resource",
+		},
+		{
+			name:     "Real file stays file",
+			path:     "/downloads/report.pdf",
+			expected: "/downloads/file",
+		},
+		{
+			name:     "Numbered file stays file",
+			path:     "/backups/backup.001",
+			expected: "/backups/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DotPrefixExtraction = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_KnownRouteTemplates(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		unmatchedValue string
+		expected       string
+	}{
+		{
+			name:     "Known template passes through",
+			path:     "/users/42/orders",
+			expected: "/users/numeric_id/orders",
+		},
+		{
+			name:     "Unknown route collapses",
+			path:     "/wp-admin/setup-config.php",
+			expected: "/unmatched",
+		},
+		{
+			name:           "Custom unmatched value",
+			path:           "/admin/login",
+			unmatchedValue: "/other",
+			expected:       "/other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.KnownRouteTemplates = []string{"/users/numeric_id/orders", "/health"}
+			if tt.unmatchedValue != "" {
+				cfg.UnmatchedValue = tt.unmatchedValue
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_NormalizeAssetHashes(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Hashed chunk",
+			path:     "/static/js/main.4f2a9b.chunk.js",
+			expected: "/static/js/main.chunk.js",
+		},
+		{
+			name:     "Differently hashed chunk groups identically",
+			path:     "/static/js/main.9c8d7e10.chunk.js",
+			expected: "/static/js/main.chunk.js",
+		},
+		{
+			name:     "Numbered chunk",
+			path:     "/static/2.3a1f5c.chunk.js",
+			expected: "/static/2.chunk.js",
+		},
+		{
+			name:     "Unhashed file stays file",
+			path:     "/static/js/vendor.min.js",
+			expected: "/static/js/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.NormalizeAssetHashes = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_OpaqueAboveSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Path above the threshold",
+			path:     "/" + strings.Repeat("a/", 10) + "42",
+			expected: "/deep",
+		},
+		{
+			name:     "Path at the threshold",
+			path:     "/api/v1/users/42",
+			expected: "/api/v1/users/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.OpaqueAboveSegments = 4
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectGitRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Branch ref",
+			path:     "/repos/api/refs/heads/feature-xyz",
+			expected: "/repos/api/refs/heads/ref",
+		},
+		{
+			name:     "Tag ref",
+			path:     "/repos/api/refs/tags/v1.2.3",
+			expected: "/repos/api/refs/tags/ref",
+		},
+		{
+			name:     "Nested branch name",
+			path:     "/repos/api/refs/heads/feature/xyz",
+			expected: "/repos/api/refs/heads/ref",
+		},
+		{
+			name:     "Refs without heads or tags",
+			path:     "/repos/api/refs/notes",
+			expected: "/repos/api/refs/notes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectGitRefs = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_UppercaseBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior string
+		expected string
+	}{
+		{
+			name:     "Preserve",
+			behavior: "preserve",
+			expected: "/API/STATUS/numeric_id",
+		},
+		{
+			name:     "Label",
+			behavior: "label",
+			expected: "/code/code/numeric_id",
+		},
+		{
+			name:     "Lowercase",
+			behavior: "lowercase",
+			expected: "/api/status/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.UppercaseBehavior = tt.behavior
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/API/STATUS/42", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestNew_UnknownUppercaseBehavior(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.UppercaseBehavior = "shout"
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error for unknown uppercase behavior")
+	}
+}
+
+func TestAddPathHeader_SourceHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name: "First header present",
+			headers: map[string]string{
+				"X-Original-URL":  "/users/42?tab=orders",
+				"X-Forwarded-Uri": "/orders/7",
+			},
+			expected: "/users/numeric_id",
+		},
+		{
+			name:     "Second header as fallback",
+			headers:  map[string]string{"X-Forwarded-Uri": "/orders/7?page=2"},
+			expected: "/orders/numeric_id",
+		},
+		{
+			name:     "Neither header present",
+			expected: "/internal/route/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.SourceHeaders = []string{"X-Original-URL", "X-Forwarded-Uri"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/internal/route/1", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Numeric range",
+			path:     "/metrics/100-200/values",
+			expected: "/metrics/range/values",
+		},
+		{
+			name:     "Slug stays slug",
+			path:     "/bookings/booking-abc-99",
+			expected: "/bookings/slug",
+		},
+		{
+			name:     "Number stays numeric",
+			path:     "/metrics/42/values",
+			expected: "/metrics/numeric_id/values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectRange = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_CanonicalizeInsteadOfLabel(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		lenientUUID bool
+		expected    string
+	}{
+		{
+			name:     "Uppercase UUID canonicalized",
+			path:     "/users/550E8400-E29B-41D4-A716-446655440000/profile",
+			expected: "/users/550e8400-e29b-41d4-a716-446655440000/profile",
+		},
+		{
+			name:        "Dashless UUID re-dashed",
+			path:        "/users/550E8400E29B41D4A716446655440000",
+			lenientUUID: true,
+			expected:    "/users/550e8400-e29b-41d4-a716-446655440000",
+		},
+		{
+			name:     "Numeric unaffected",
+			path:     "/orders/42",
+			expected: "/orders/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CanonicalizeInsteadOfLabel = []string{"uuid"}
+			cfg.LenientUUID = tt.lenientUUID
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectDeviceToken(t *testing.T) {
+	fcmToken := "cR1nlht8Q0y2sHx7Fe3m9s:APA91b" + strings.Repeat("Hx7Fe3m9sK2_v-Lq", 9)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "APNs token",
+			path:     "/devices/" + strings.Repeat("a1b2c3d4", 8) + "/subscriptions",
+			expected: "/devices/device_token/subscriptions",
+		},
+		{
+			name:     "FCM token",
+			path:     "/devices/" + fcmToken + "/subscriptions",
+			expected: "/devices/device_token/subscriptions",
+		},
+		{
+			name:     "Shorter hex is not a device token",
+			path:     "/devices/" + strings.Repeat("a1b2c3d4", 4),
+			expected: "/devices/uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectDeviceToken = true
+			cfg.LenientUUID = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_QueryStatsHeaderName(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{
+			name:     "Three parameters",
+			target:   "/search?q=shoes&page=2&sort=price",
+			expected: "params=3",
+		},
+		{
+			name:     "No query string",
+			target:   "/search",
+			expected: "params=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.QueryStatsHeaderName = "x-query-stats"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-query-stats")
+				if got != tt.expected {
+					t.Errorf("expected query stats %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ObjectIDParents(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "ObjectId after a listed parent",
+			path:     "/orders/507f1f77bcf86cd799439011/items",
+			expected: "/orders/objectid/items",
+		},
+		{
+			name:     "ObjectId after an unlisted parent",
+			path:     "/commits/507f1f77bcf86cd799439011/items",
+			expected: "/commits/slug/items",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ObjectIDParents = []string{"orders", "products"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_EmptySegmentBehavior(t *testing.T) {
+	tests := []struct {
+		name           string
+		behavior       string
+		expected       string
+		expectedStatus int
+	}{
+		{
+			name:           "Drop",
+			behavior:       "drop",
+			expected:       "/api/v1/users",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Preserve",
+			behavior:       "preserve",
+			expected:       "/api/empty/v1/users",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Reject",
+			behavior:       "reject",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.EmptySegmentBehavior = tt.behavior
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api//v1/users", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rw.Code)
+			}
+		})
+	}
+}
+
+func TestNew_UnknownEmptySegmentBehavior(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.EmptySegmentBehavior = "collapse"
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware"); err == nil {
+		t.Error("expected error for unknown empty segment behavior")
+	}
+}
+
+func TestAddPathHeader_DetectBase32(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Base32 secret",
+			path:     "/verify/JBSWY3DPEHPK3PXP/check",
+			expected: "/verify/base32/check",
+		},
+		{
+			name:     "Padded base32",
+			path:     "/verify/MFRGGZDFMZTWQ2LKNNWG23Q=/check",
+			expected: "/verify/base32/check",
+		},
+		{
+			name:     "ULID stays ulid",
+			path:     "/verify/01ARZ3NDEKTSV4RRFFQ69G5FAV/check",
+			expected: "/verify/ulid/check",
+		},
+		{
+			name:     "Word stays literal",
+			path:     "/verify/CONFIGURATIONS/check",
+			expected: "/verify/CONFIGURATIONS/check",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectBase32 = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_LearnSink(t *testing.T) {
+	var groups []string
+
+	cfg := CreateConfig()
+	cfg.LearnSink = func(group string) {
+		groups = append(groups, group)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	for _, path := range []string{"/users/1", "/users/2", "/orders/3", "/users/4"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := []string{"/users/numeric_id", "/orders/numeric_id"}
+	if strings.Join(groups, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected learned groups %v, got %v", expected, groups)
+	}
+}
+
+func TestAddPathHeader_RouteSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Matching path",
+			path:     "/api/v1/users/550e8400-e29b-41d4-a716-446655440000/bookings/42",
+			expected: "",
+		},
+		{
+			name:     "Slug where a uuid was expected",
+			path:     "/api/v1/users/john-doe-1/bookings/42",
+			expected: "position=3 expected=uuid got=slug",
+		},
+		{
+			name:     "Path outside the schema prefix",
+			path:     "/api/v1/orders/john-doe-1",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.RouteSchemas = []RouteSchema{
+				{Prefix: "/api/v1/users", Labels: []string{"uuid", "literal", "numeric_id"}},
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("X-Path-Schema-Mismatch")
+				if got != tt.expected {
+					t.Errorf("expected schema mismatch %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectIBAN(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Valid IBAN",
+			path:     "/accounts/DE89370400440532013000/tx",
+			expected: "/accounts/iban/tx",
+		},
+		{
+			name:     "Valid IBAN with letters",
+			path:     "/accounts/GB82WEST12345698765432/tx",
+			expected: "/accounts/iban/tx",
+		},
+		{
+			name:     "Invalid checksum falls back to slug",
+			path:     "/accounts/DE89370400440532013001/tx",
+			expected: "/accounts/slug/tx",
+		},
+		{
+			name:     "Short non-IBAN",
+			path:     "/accounts/DE89/tx",
+			expected: "/accounts/DE89/tx",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectIBAN = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_CompoundDelimiters(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		minFraction float64
+		expected    string
+	}{
+		{
+			name:     "Pipe-delimited compound",
+			path:     "/lookup/42|abc-1|550e8400-e29b-41d4-a716-446655440000",
+			expected: "/lookup/numeric_id|slug|uuid",
+		},
+		{
+			name:     "Delimiter in a non-ID segment",
+			path:     "/games/rock|paper",
+			expected: "/games/rock|paper",
+		},
+		{
+			name:        "Partially classified compound above the fraction",
+			path:        "/lookup/42|primary",
+			minFraction: 0.5,
+			expected:    "/lookup/numeric_id|primary",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CompoundDelimiters = []string{"|"}
+			if tt.minFraction != 0 {
+				cfg.CompoundMinFraction = tt.minFraction
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_SelfAliases(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		selfLabel string
+		expected  string
+	}{
+		{
+			name:     "Self alias groups with numeric IDs",
+			path:     "/users/me/profile",
+			expected: "/users/numeric_id/profile",
+		},
+		{
+			name:     "Numeric ID sibling",
+			path:     "/users/42/profile",
+			expected: "/users/numeric_id/profile",
+		},
+		{
+			name:      "Self alias with a uuid label",
+			path:      "/users/current/profile",
+			selfLabel: "uuid",
+			expected:  "/users/uuid/profile",
+		},
+		{
+			name:     "Other literals unchanged",
+			path:     "/users/search",
+			expected: "/users/search",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.SelfAliases = []string{"me", "self", "current"}
+			if tt.selfLabel != "" {
+				cfg.SelfLabel = tt.selfLabel
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_GRPCServiceHeaderName(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		expectedGroup   string
+		expectedService string
+	}{
+		{
+			name:            "gRPC method path",
+			path:            "/booking.v1.BookingService/GetBooking",
+			expectedGroup:   "/booking.v1.BookingService/GetBooking",
+			expectedService: "booking.v1.BookingService",
+		},
+		{
+			name:            "REST path",
+			path:            "/api/v1/bookings/42",
+			expectedGroup:   "/api/v1/bookings/numeric_id",
+			expectedService: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.GRPCServiceHeaderName = "x-grpc-service"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expectedGroup {
+					t.Errorf("expected path group %q, got %q", tt.expectedGroup, got)
+				}
+				if got := req.Header.Get("x-grpc-service"); got != tt.expectedService {
+					t.Errorf("expected gRPC service %q, got %q", tt.expectedService, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ReservedSubresources(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Avatar stays literal",
+			path:     "/users/42/avatar",
+			expected: "/random/numeric_id/avatar",
+		},
+		{
+			name:     "Items stays literal",
+			path:     "/orders/7/items",
+			expected: "/random/numeric_id/items",
+		},
+		{
+			name:     "Settings stays literal",
+			path:     "/accounts/9/settings",
+			expected: "/random/numeric_id/settings",
+		},
+		{
+			name:     "Unreserved segment labeled by the aggressive detector",
+			path:     "/accounts/9/billing",
+			expected: "/random/numeric_id/random",
+		},
+		{
+			name:     "Git ref stays literal",
+			path:     "/refs/heads/items",
+			expected: "/refs/heads/items",
+		},
+		{
+			name:     "Aggregate suffix not tagged",
+			path:     "/accounts/9/items",
+			expected: "/random/numeric_id/items",
+		},
+		{
+			name:     "Action verb not tagged",
+			path:     "/accounts/9/settings/billing",
+			expected: "/random/numeric_id/settings/random",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ReservedSubresources = []string{"avatar", "items", "settings", "refs", "heads"}
+			cfg.EntropyThreshold = 0.5
+			cfg.EntropyMinLength = 1
+			cfg.DetectGitRefs = true
+			cfg.AggregateSuffixes = []string{"items"}
+			cfg.ActionVerbs = []string{"settings"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_GenericizeIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "UUID and numeric IDs collapse to id",
+			path:     "/users/550e8400-e29b-41d4-a716-446655440000/orders/42",
+			expected: "/users/id/orders/id",
+		},
+		{
+			name:     "ULID collapses to id",
+			path:     "/events/01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			expected: "/events/id",
+		},
+		{
+			name:     "File and ISO date stay distinct",
+			path:     "/reports/2024-01-15/summary.pdf",
+			expected: "/reports/iso_date/file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.GenericizeIDs = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectGeohash(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Eleven-character geohash",
+			path:     "/area/u4pruydqqvj/nearby",
+			expected: "/area/geohash/nearby",
+		},
+		{
+			name:     "Short word stays literal",
+			path:     "/area/users/nearby",
+			expected: "/area/users/nearby",
+		},
+		{
+			name:     "Six-character geohash",
+			path:     "/area/u4pruy/nearby",
+			expected: "/area/geohash/nearby",
+		},
+		{
+			name:     "Versioned tokens stay literal",
+			path:     "/area/http2/utf8/h264/web3/nearby",
+			expected: "/area/http2/utf8/h264/web3/nearby",
+		},
+		{
+			name:     "ULID stays ulid",
+			path:     "/area/01ARZ3NDEKTSV4RRFFQ69G5FAV/nearby",
+			expected: "/area/ulid/nearby",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectGeohash = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_BaggageKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		baggage      string
+		outputFormat string
+		expected     string
+	}{
+		{
+			name:     "Empty baggage",
+			expected: "route=%2Fapi%2Fv1%2Fusers%2Fnumeric_id",
+		},
+		{
+			name:     "Existing baggage",
+			baggage:  "userId=alice,tenant=acme;ttl=60",
+			expected: "userId=alice,tenant=acme;ttl=60,route=%2Fapi%2Fv1%2Fusers%2Fnumeric_id",
+		},
+		{
+			name:     "Existing route entry replaced",
+			baggage:  "route=%2Fold, userId=alice",
+			expected: "userId=alice,route=%2Fapi%2Fv1%2Fusers%2Fnumeric_id",
+		},
+		{
+			name:         "Space percent-encoded",
+			outputFormat: outputFormatDatadog,
+			expected:     "route=GET%20%2Fapi%2Fv1%2Fusers%2F%7Bnumeric_id%7D",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.BaggageKey = "route"
+			if tt.outputFormat != "" {
+				cfg.OutputFormat = tt.outputFormat
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("Baggage")
+				if got != tt.expected {
+					t.Errorf("expected baggage %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+			if tt.baggage != "" {
+				req.Header.Set("Baggage", tt.baggage)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_RiskHeaderName(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Fully classified path",
+			path:     "/api/v1/users/42",
+			expected: "1",
+		},
+		{
+			name:     "Unrecognized token",
+			path:     "/api/v1/users/x~9Kq2",
+			expected: "2",
+		},
+		{
+			name:     "Literal prefix only",
+			path:     "/api/health",
+			expected: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.RiskHeaderName = "x-path-risk"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-risk")
+				if got != tt.expected {
+					t.Errorf("expected risk score %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_AggregateSuffixes(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		aggregateLabel string
+		expected       string
+	}{
+		{
+			name:     "Trailing aggregate suffix tagged",
+			path:     "/users/42/posts/count",
+			expected: "/users/numeric_id/posts/aggregate_count",
+		},
+		{
+			name:     "Normal trailing literal unaffected",
+			path:     "/users/42/posts",
+			expected: "/users/numeric_id/posts",
+		},
+		{
+			name:     "Aggregate suffix not in trailing position",
+			path:     "/stats/users/42",
+			expected: "/stats/users/numeric_id",
+		},
+		{
+			name:           "Custom aggregate label",
+			path:           "/orders/sum",
+			aggregateLabel: "agg",
+			expected:       "/orders/agg_sum",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.AggregateSuffixes = []string{"count", "sum", "stats"}
+			if tt.aggregateLabel != "" {
+				cfg.AggregateLabel = tt.aggregateLabel
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_LegacyStarMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "UUID and numeric IDs",
+			path:     "/users/550e8400-e29b-41d4-a716-446655440000/posts/42",
+			expected: "/users/*/posts/*",
+		},
+		{
+			name:     "Slug with digit and separator",
+			path:     "/articles/hello-world-2024",
+			expected: "/articles/*",
+		},
+		{
+			name:     "Other formats stay literal",
+			path:     "/events/01ARZ3NDEKTSV4RRFFQ69G5FAV/report.pdf",
+			expected: "/events/01ARZ3NDEKTSV4RRFFQ69G5FAV/report.pdf",
+		},
+		{
+			name:     "Alphanumeric without separator stays literal",
+			path:     "/codes/abc12345",
+			expected: "/codes/abc12345",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LegacyStarMode = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectOAuthState(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "State value after its key",
+			path:     "/callback/state/9f8d7a6b5c4e3f2a1b0c/continue",
+			expected: "/callback/state/token/continue",
+		},
+		{
+			name:     "Nonce value after its key",
+			path:     "/callback/nonce/n0nce5f2a9c/continue",
+			expected: "/callback/nonce/token/continue",
+		},
+		{
+			name:     "Word after a key",
+			path:     "/oauth/code/items",
+			expected: "/oauth/code/items",
+		},
+		{
+			name:     "Non-matching key",
+			path:     "/callback/provider/github/continue",
+			expected: "/callback/provider/github/continue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectOAuthState = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_RouteTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Matching path",
+			path:     "/api/v1/users/550e8400-e29b-41d4-a716-446655440000",
+			expected: "/api/v1/users/{id}",
+		},
+		{
+			name:     "Literal preferred over placeholder",
+			path:     "/api/v1/users/me",
+			expected: "/api/v1/users/me",
+		},
+		{
+			name:     "Nested placeholders",
+			path:     "/api/v1/users/42/orders/7",
+			expected: "/api/v1/users/{id}/orders/{orderId}",
+		},
+		{
+			name:     "Partial match",
+			path:     "/api/v1/users",
+			expected: "/unmatched",
+		},
+		{
+			name:     "Unmatched path",
+			path:     "/wp-login.php",
+			expected: "/unmatched",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.RouteTemplates = []string{
+				"/api/v1/users/{id}",
+				"/api/v1/users/me",
+				"/api/v1/users/{id}/orders/{orderId}",
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_CollapseNonASCII(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Emoji segment",
+			path:     "/tags/%F0%9F%8E%89party/posts",
+			expected: "/tags/unicode/posts",
+		},
+		{
+			name:     "CJK segment",
+			path:     "/articles/%E6%9D%B1%E4%BA%AC",
+			expected: "/articles/unicode",
+		},
+		{
+			name:     "ASCII word unaffected",
+			path:     "/tags/party/posts",
+			expected: "/tags/party/posts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CollapseNonASCII = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_FullTargetHeaderName(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		host     string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "Full URL target",
+			target:   "https://API.Example.com:8443/api/v1/users/550e8400-e29b-41d4-a716-446655440000",
+			expected: "https://api.example.com/api/v1/users/uuid",
+		},
+		{
+			name:     "Forwarded scheme and host",
+			target:   "/api/v1/users/42",
+			headers:  map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "tenant.example.com"},
+			expected: "https://tenant.example.com/api/v1/users/numeric_id",
+		},
+		{
+			name:     "Multi-hop forwarded headers",
+			target:   "/api/v1/users/42",
+			headers:  map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "a.com, b.com"},
+			expected: "https://a.com/api/v1/users/numeric_id",
+		},
+		{
+			name:     "Path-only request",
+			target:   "/api/v1/users/42",
+			host:     "",
+			expected: "/api/v1/users/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.FullTargetHeaderName = "x-full-target"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-full-target")
+				if got != tt.expected {
+					t.Errorf("expected full target %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if !strings.HasPrefix(tt.target, "http") {
+				req.Host = tt.host
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_SignatureSuffixKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Hex signature after its key",
+			path:     "/download/file/sig/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			expected: "/download/file/sig/signature",
+		},
+		{
+			name:     "Base64 signature after its key",
+			path:     "/download/file/signature/dGhpcyBpcyBhIHNpZ25hdHVyZQ==",
+			expected: "/download/file/signature/signature",
+		},
+		{
+			name:     "Non-signature trailing segment",
+			path:     "/download/file/sig/latest",
+			expected: "/download/file/sig/latest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.SignatureSuffixKeys = []string{"sig", "signature", "token"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_MaxDistinctGroups(t *testing.T) {
+	logger := &fakeLogger{}
+	cfg := CreateConfig()
+	cfg.MaxDistinctGroups = 2
+	cfg.Logger = logger
+
+	var groups []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		groups = append(groups, req.Header.Get("x-path-group"))
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	paths := []string{"/users/42", "/orders/7", "/users/43", "/products/1", "/invoices/2", "/orders/8"}
+	for _, path := range paths {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := []string{"/users/numeric_id", "/orders/numeric_id", "/users/numeric_id", "/overflow", "/overflow", "/orders/numeric_id"}
+	if strings.Join(groups, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+	if len(logger.entries) != 1 {
+		t.Fatalf("expected 1 overflow warning, got %v", logger.entries)
+	}
+	if !strings.Contains(logger.entries[0], "/overflow") {
+		t.Errorf("expected warning to mention the overflow group, got %q", logger.entries[0])
+	}
+}
+
+func TestAddPathHeader_DetectTraceIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		detect      bool
+		lenientUUID bool
+		expected    string
+	}{
+		{
+			name:     "Trace and span IDs",
+			path:     "/trace/4bf92f3577b34da6a3ce929d0e0e4736/span/00f067aa0ba902b7",
+			detect:   true,
+			expected: "/trace/trace_id/span/span_id",
+		},
+		{
+			name:        "Trace ID takes precedence over dashless UUID",
+			path:        "/trace/4bf92f3577b34da6a3ce929d0e0e4736",
+			detect:      true,
+			lenientUUID: true,
+			expected:    "/trace/trace_id",
+		},
+		{
+			name:        "Dashless UUID without the flag",
+			path:        "/trace/4bf92f3577b34da6a3ce929d0e0e4736",
+			lenientUUID: true,
+			expected:    "/trace/uuid",
+		},
+		{
+			name:     "SHA-256 digest is not a trace ID",
+			path:     "/blobs/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			detect:   true,
+			expected: "/blobs/slug",
+		},
+		{
+			name:     "All-digit span stays numeric",
+			path:     "/span/1234567890123456",
+			detect:   true,
+			expected: "/span/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectTraceIDs = tt.detect
+			cfg.LenientUUID = tt.lenientUUID
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ExampleSink(t *testing.T) {
+	examples := map[string][]string{}
+	cfg := CreateConfig()
+	cfg.ExampleWindow = time.Minute
+	cfg.ExampleSink = func(label, original string) {
+		examples[label] = append(examples[label], original)
+	}
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	clock := time.Now()
+	handler.(*AddPathHeader).now = func() time.Time {
+		return clock
+	}
+
+	serve := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("/users/42/orders/550e8400-e29b-41d4-a716-446655440000")
+	serve("/users/43")
+	serve("/users/44/orders/6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	if got := strings.Join(examples[labelNumericID], ","); got != "42" {
+		t.Errorf("expected a single numeric_id example within the window, got %q", got)
+	}
+	if got := strings.Join(examples[labelUUID], ","); got != "550e8400-e29b-41d4-a716-446655440000" {
+		t.Errorf("expected a single uuid example within the window, got %q", got)
+	}
+
+	clock = clock.Add(time.Minute)
+	serve("/users/45")
+
+	if got := strings.Join(examples[labelNumericID], ","); got != "42,45" {
+		t.Errorf("expected a new numeric_id example after the window, got %q", got)
+	}
+}
+
+func TestAddPathHeader_SourceHeaderQuery(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          string
+		expected       string
+		expectedParams string
+	}{
+		{
+			name:           "Query split from the path",
+			value:          "/api/users/42?x=1",
+			expected:       "/api/users/numeric_id",
+			expectedParams: "params=1",
+		},
+		{
+			name:           "Split on the first question mark",
+			value:          "/api/users/42?next=/login?retry=1&x=2",
+			expected:       "/api/users/numeric_id",
+			expectedParams: "params=2",
+		},
+		{
+			name:           "No query",
+			value:          "/api/users/42",
+			expected:       "/api/users/numeric_id",
+			expectedParams: "params=0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.SourceHeaders = []string{"X-Original-URL"}
+			cfg.QueryStatsHeaderName = "X-Query-Stats"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+				if got := req.Header.Get("X-Query-Stats"); got != tt.expectedParams {
+					t.Errorf("expected query stats %q, got %q", tt.expectedParams, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/internal/route/1?a=1&b=2&c=3", nil)
+			req.Header.Set("X-Original-URL", tt.value)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectMediaSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		detect   bool
+		expected string
+	}{
+		{
+			name:     "Literal base with media suffix",
+			path:     "/api/users.v2+json",
+			detect:   true,
+			expected: "/api/users.media_type",
+		},
+		{
+			name:     "ID base with media suffix",
+			path:     "/api/users/42.v2+json",
+			detect:   true,
+			expected: "/api/users/numeric_id.media_type",
+		},
+		{
+			name:     "Normal file",
+			path:     "/api/report.pdf",
+			detect:   true,
+			expected: "/api/file",
+		},
+		{
+			name:     "Disabled",
+			path:     "/api/users.v2+json",
+			expected: "/api/users.v2+json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectMediaSuffix = tt.detect
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"headerName": "X-Route", "detectBool": true}`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %v", err)
+	}
+	if cfg.HeaderName != "X-Route" || !cfg.DetectBool {
+		t.Errorf("expected the configured fields to be set, got headerName %q detectBool %v", cfg.HeaderName, cfg.DetectBool)
+	}
+	if cfg.UnmatchedValue != defaultUnmatchedValue {
+		t.Errorf("expected unset fields to keep their default, got unmatchedValue %q", cfg.UnmatchedValue)
+	}
+}
+
+func TestParseConfig_UnknownField(t *testing.T) {
+	if _, err := ParseConfig([]byte(`{"headerNam": "X-Route"}`)); err == nil {
+		t.Error("expected error for unknown config field")
+	}
+}
+
+func TestAddPathHeader_DetectArrayIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		detect   bool
+		expected string
+	}{
+		{
+			name:     "Bracketed index",
+			path:     "/items/[0]/value",
+			detect:   true,
+			expected: "/items/index/value",
+		},
+		{
+			name:     "Bare index",
+			path:     "/items/0/value",
+			detect:   true,
+			expected: "/items/index/value",
+		},
+		{
+			name:     "Bare index without the flag",
+			path:     "/items/0/value",
+			expected: "/items/numeric_id/value",
+		},
+		{
+			name:     "Bracketed non-numeric",
+			path:     "/items/[abc]/value",
+			detect:   true,
+			expected: "/items/[abc]/value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectArrayIndex = tt.detect
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ContentTypeGroups(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		expected    string
+	}{
+		{
+			name:        "Multipart upload tagged",
+			contentType: "multipart/form-data; boundary=----abc",
+			expected:    "/files/numeric_id/upload",
+		},
+		{
+			name:        "JSON request not tagged",
+			contentType: "application/json",
+			expected:    "/files/numeric_id",
+		},
+		{
+			name:     "No content type",
+			expected: "/files/numeric_id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ContentTypeGroups = map[string]string{"multipart/form-data": "upload"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/files/42", nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		detect   bool
+		expected string
+	}{
+		{
+			name:     "Nil UUID",
+			path:     "/users/00000000-0000-0000-0000-000000000000",
+			detect:   true,
+			expected: "/users/placeholder",
+		},
+		{
+			name:     "All-zero number",
+			path:     "/users/000",
+			detect:   true,
+			expected: "/users/placeholder",
+		},
+		{
+			name:     "Sequential digits",
+			path:     "/users/123456789",
+			detect:   true,
+			expected: "/users/placeholder",
+		},
+		{
+			name:     "Real UUID stays uuid",
+			path:     "/users/550e8400-e29b-41d4-a716-446655440000",
+			detect:   true,
+			expected: "/users/uuid",
+		},
+		{
+			name:     "Real number stays numeric_id",
+			path:     "/users/48213",
+			detect:   true,
+			expected: "/users/numeric_id",
+		},
+		{
+			name:     "Nil UUID without the flag",
+			path:     "/users/00000000-0000-0000-0000-000000000000",
+			expected: "/users/uuid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectPlaceholders = tt.detect
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_IncludeMiddlewareName(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  bool
+		expected string
+	}{
+		{
+			name:     "Name included",
+			include:  true,
+			expected: "api-path-group",
+		},
+		{
+			name:     "Disabled by default",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.IncludeMiddlewareName = tt.include
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("X-Path-Group-By")
+				if got != tt.expected {
+					t.Errorf("expected middleware name header %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "api-path-group")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_CollapseTraversalAfter(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		expected      string
+		expectedAudit string
+	}{
+		{
+			name:          "Long traversal collapsed",
+			path:          "/graph/v/42/e/57/v/88/e/91",
+			expected:      "/graph/v/numeric_id/e/numeric_id/traversal",
+			expectedAudit: "2=42,4=57",
+		},
+		{
+			name:          "Segments after the run kept",
+			path:          "/graph/v/42/e/57/v/88/e/91/v/7/edges",
+			expected:      "/graph/v/numeric_id/e/numeric_id/traversal/edges",
+			expectedAudit: "2=42,4=57",
+		},
+		{
+			name:          "Short traversal stays expanded",
+			path:          "/graph/v/42/e/57",
+			expected:      "/graph/v/numeric_id/e/numeric_id",
+			expectedAudit: "2=42,4=57",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CollapseTraversalAfter = 2
+			cfg.AuditHeaderName = "X-Path-Audit"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+				if got := req.Header.Get("X-Path-Audit"); got != tt.expectedAudit {
+					t.Errorf("expected audit %q, got %q", tt.expectedAudit, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestConfig_CardinalityProfile(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.TypedPrefixMode = true
+	profile := cfg.CardinalityProfile()
+
+	if safe, ok := profile["uuid"]; !ok || !safe {
+		t.Errorf("expected uuid to be bounded, got %v (present %v)", safe, ok)
+	}
+	if safe, ok := profile["typed_prefix"]; !ok || safe {
+		t.Errorf("expected typed_prefix to be unbounded, got %v (present %v)", safe, ok)
+	}
+	if safe, ok := profile["literal"]; !ok || safe {
+		t.Errorf("expected the literal fallback to be unbounded, got %v (present %v)", safe, ok)
+	}
+	if _, ok := profile["geohash"]; ok {
+		t.Error("expected disabled detectors to be left out")
+	}
+}
+
+func TestConfig_CardinalityProfileUnboundedOutputs(t *testing.T) {
+	tests := []struct {
+		name       string
+		configure  func(cfg *Config)
+		bounded    []string
+		notBounded []string
+	}{
+		{
+			name: "Canonicalized label",
+			configure: func(cfg *Config) {
+				cfg.CanonicalizeInsteadOfLabel = []string{"uuid"}
+			},
+			bounded:    []string{"numeric_id"},
+			notBounded: []string{"uuid", "lenient_uuid"},
+		},
+		{
+			name: "Canonicalized label genericized",
+			configure: func(cfg *Config) {
+				cfg.CanonicalizeInsteadOfLabel = []string{"uuid"}
+				cfg.GenericizeIDs = true
+			},
+			bounded:    []string{"numeric_id"},
+			notBounded: []string{"uuid"},
+		},
+		{
+			name: "Original inline",
+			configure: func(cfg *Config) {
+				cfg.IncludeOriginalInline = true
+				cfg.CompoundDelimiters = []string{"|"}
+				cfg.CompoundMinFraction = 1
+			},
+			bounded:    []string{"card_number"},
+			notBounded: []string{"uuid", "numeric_id", "compound"},
+		},
+		{
+			name:       "Compression suffixes",
+			configure:  func(cfg *Config) {},
+			bounded:    []string{"file"},
+			notBounded: []string{"compression_suffix"},
+		},
+		{
+			name: "Date aware files",
+			configure: func(cfg *Config) {
+				cfg.DateAwareFiles = true
+			},
+			notBounded: []string{"date_file"},
+		},
+		{
+			name: "Date aware files without extension",
+			configure: func(cfg *Config) {
+				cfg.DateAwareFiles = true
+				cfg.DateFileKeepExtension = false
+			},
+			bounded: []string{"date_file"},
+		},
+		{
+			name: "Kept format suffix",
+			configure: func(cfg *Config) {
+				cfg.KeepFormatSuffix = []string{"json"}
+			},
+			notBounded: []string{"format_suffix"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.LenientUUID = true
+			tt.configure(cfg)
+			profile := cfg.CardinalityProfile()
+
+			for _, name := range tt.bounded {
+				if safe, ok := profile[name]; !ok || !safe {
+					t.Errorf("expected %s to be bounded, got %v (present %v)", name, safe, ok)
+				}
+			}
+			for _, name := range tt.notBounded {
+				if safe, ok := profile[name]; !ok || safe {
+					t.Errorf("expected %s to be unbounded, got %v (present %v)", name, safe, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_CursorLengths(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "27-char cursor",
+			path:     "/feed/PD0-P0BBQkNERUZHSElKS0xNTk8",
+			expected: "/feed/cursor",
+		},
+		{
+			name:     "43-char cursor",
+			path:     "/feed/PD0-P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZWls",
+			expected: "/feed/cursor",
+		},
+		{
+			name:     "Non-listed length",
+			path:     "/feed/V1StGXR8_Z5jdHi6B-myT",
+			expected: "/feed/nanoid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CursorLengths = []int{27, 43}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_PrefixHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		expectedHeader string
+		expected       string
+	}{
+		{
+			name:           "API prefix",
+			path:           "/api/users/42",
+			expectedHeader: "X-Group-Api",
+			expected:       "/api/users/numeric_id",
+		},
+		{
+			name:           "Admin prefix",
+			path:           "/admin/users/42",
+			expectedHeader: "X-Group-Admin",
+			expected:       "/admin/users/numeric_id",
+		},
+		{
+			name:           "Unmatched path uses the default header",
+			path:           "/health/42",
+			expectedHeader: "x-path-group",
+			expected:       "/health/numeric_id",
+		},
+	}
+
+	headerNames := []string{"X-Group-Api", "X-Group-Admin", "x-path-group"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PrefixHeaders = map[string]string{
+				"/api/":   "X-Group-Api",
+				"/admin/": "X-Group-Admin",
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				for _, name := range headerNames {
+					expected := ""
+					if name == tt.expectedHeader {
+						expected = tt.expected
+					}
+					if got := req.Header.Get(name); got != expected {
+						t.Errorf("expected %s %q, got %q", name, expected, got)
+					}
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_DetectHexGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		detect   bool
+		expected string
+	}{
+		{
+			name:     "Two hex groups",
+			path:     "/shards/3f2a-9b1c",
+			detect:   true,
+			expected: "/shards/hex_key",
+		},
+		{
+			name:     "Full UUID stays uuid",
+			path:     "/shards/550e8400-e29b-41d4-a716-446655440000",
+			detect:   true,
+			expected: "/shards/uuid",
+		},
+		{
+			name:     "Non-hex hyphenated word stays slug",
+			path:     "/shards/shard-9x2k",
+			detect:   true,
+			expected: "/shards/slug",
+		},
+		{
+			name:     "Disabled",
+			path:     "/shards/3f2a-9b1c",
+			expected: "/shards/slug",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.DetectHexGroups = tt.detect
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("x-path-group")
+				if got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_PathMetricsHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		expectedLength string
+		expectedDepth  string
+	}{
+		{
+			name:           "Deep long path",
+			path:           "/api/v1/users/550e8400-e29b-41d4-a716-446655440000/orders/42/items/7",
+			expectedLength: "68",
+			expectedDepth:  "8",
+		},
+		{
+			name:           "Short path",
+			path:           "/health",
+			expectedLength: "7",
+			expectedDepth:  "1",
+		},
+		{
+			name:           "Root path",
+			path:           "/",
+			expectedLength: "1",
+			expectedDepth:  "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.PathMetricsHeaders = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("X-Path-Length"); got != tt.expectedLength {
+					t.Errorf("expected path length %q, got %q", tt.expectedLength, got)
+				}
+				if got := req.Header.Get("X-Path-Depth"); got != tt.expectedDepth {
+					t.Errorf("expected path depth %q, got %q", tt.expectedDepth, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_ExampleSinkSkipsCardNumbers(t *testing.T) {
+	var examples []string
+	cfg := CreateConfig()
+	cfg.ExampleSink = func(label, original string) {
+		examples = append(examples, label+"="+original)
+	}
+
+	handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/cards/4111111111111111/charges/42", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := strings.Join(examples, ","); got != "numeric_id=42" {
+		t.Errorf("expected only the numeric_id example, got %q", got)
+	}
+}
+
+func TestAddPathHeader_CardNumbersRedactedUnderOtherLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		arrayIndex     bool
+		paginationKeys map[string]string
+		inline         bool
+		expected       string
+		expectedAudit  string
+	}{
+		{
+			name:       "Array index inline",
+			path:       "/cards/4111111111111111",
+			arrayIndex: true,
+			inline:     true,
+			expected:   "/cards/index",
+		},
+		{
+			name:          "Array index audit",
+			path:          "/cards/4111111111111111/charges/7",
+			arrayIndex:    true,
+			expected:      "/cards/index/charges/index",
+			expectedAudit: "3=7",
+		},
+		{
+			name:          "Bracketed array index audit",
+			path:          "/cards/[4111111111111111]",
+			arrayIndex:    true,
+			expected:      "/cards/index",
+			expectedAudit: "",
+		},
+		{
+			name:           "Pagination key audit",
+			path:           "/page/4111111111111111",
+			paginationKeys: map[string]string{"page": "page_number"},
+			expected:       "/page/page_number",
+			expectedAudit:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var samples []string
+			cfg := CreateConfig()
+			cfg.DetectArrayIndex = tt.arrayIndex
+			cfg.PaginationKeys = tt.paginationKeys
+			cfg.IncludeOriginalInline = tt.inline
+			cfg.AuditHeaderName = "X-Path-Audit"
+			cfg.ExampleSink = func(label, original string) {
+				samples = append(samples, original)
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+				if got := req.Header.Get("X-Path-Audit"); got != tt.expectedAudit {
+					t.Errorf("expected audit header %q, got %q", tt.expectedAudit, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			for _, sample := range samples {
+				if strings.Contains(sample, "4111111111111111") {
+					t.Errorf("expected the card number never to be sampled, got %q", sample)
+				}
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_LogsRedactCardNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(a *AddPathHeader)
+	}{
+		{
+			name: "Slow classification log",
+			setup: func(a *AddPathHeader) {
+				clock := time.Now()
+				a.now = func() time.Time {
+					clock = clock.Add(10 * time.Millisecond)
+					return clock
+				}
+			},
+		},
+		{
+			name: "Panic recovery log",
+			setup: func(a *AddPathHeader) {
+				a.classify = func(segment string) string {
+					panic("broken classifier")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &fakeLogger{}
+			cfg := CreateConfig()
+			cfg.SlowLogThresholdMs = 5
+			cfg.Logger = logger
+
+			handler, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+			tt.setup(handler.(*AddPathHeader))
+
+			req := httptest.NewRequest(http.MethodGet, "/cards/4111111111111111", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(logger.entries) != 1 {
+				t.Fatalf("expected 1 log entry, got %v", logger.entries)
+			}
+			if strings.Contains(logger.entries[0], "4111111111111111") {
+				t.Errorf("expected the card number to be redacted, got %q", logger.entries[0])
+			}
+			if !strings.Contains(logger.entries[0], "/cards/redacted") {
+				t.Errorf("expected the redacted path in the log, got %q", logger.entries[0])
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_PartialHostOverrideKeepsDefaults(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"hostOverrides": {"a.com": {"headerName": "x-a", "auditHeaderName": "X-Path-Audit"}}}`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %v", err)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if got := req.Header.Get("x-a"); got != "/cards/redacted/charges/numeric_id" {
+			t.Errorf("expected path group %q, got %q", "/cards/redacted/charges/numeric_id", got)
+		}
+		if got := req.Header.Get("X-Path-Audit"); got != "3=42" {
+			t.Errorf("expected audit header %q, got %q", "3=42", got)
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	override := handler.(*AddPathHeader).exactHosts["a.com"]
+	if !override.failOpen || !override.handleWellKnown {
+		t.Errorf("expected the override to keep the default-on options, got failOpen %v handleWellKnown %v", override.failOpen, override.handleWellKnown)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/cards/4111111111111111/charges/42", nil)
+	req.Host = "a.com"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAddPathHeader_HostOverrideTurnsOffDefault(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"hostOverrides": {"a.com": {"redactCardNumbers": false, "failOpen": false}}}`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %v", err)
+	}
+
+	var groups []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		groups = append(groups, req.Header.Get("x-path-group"))
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	if override := handler.(*AddPathHeader).exactHosts["a.com"]; override.failOpen {
+		t.Error("expected the override to turn off failOpen")
+	}
+
+	for _, host := range []string{"a.com", "b.com"} {
+		req := httptest.NewRequest(http.MethodGet, "/u/4111111111111111", nil)
+		req.Host = host
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := []string{"/u/numeric_id", "/u/redacted"}
+	if strings.Join(groups, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+}
+
+func TestAddPathHeader_IncludeFragmentFromSourceHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          string
+		expected       string
+		expectedParams string
+	}{
+		{
+			name:           "Fragment route",
+			value:          "/app/7#/users/42",
+			expected:       "/app/numeric_id#/users/numeric_id",
+			expectedParams: "params=0",
+		},
+		{
+			name:           "Fragment after a query",
+			value:          "/app/7?x=1#/users/42",
+			expected:       "/app/numeric_id#/users/numeric_id",
+			expectedParams: "params=1",
+		},
+		{
+			name:           "No fragment",
+			value:          "/app/7?x=1",
+			expected:       "/app/numeric_id",
+			expectedParams: "params=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.IncludeFragment = true
+			cfg.SourceHeaders = []string{"X-Original-URL"}
+			cfg.QueryStatsHeaderName = "X-Query-Stats"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-group"); got != tt.expected {
+					t.Errorf("expected path group %q, got %q", tt.expected, got)
+				}
+				if got := req.Header.Get("X-Query-Stats"); got != tt.expectedParams {
+					t.Errorf("expected query stats %q, got %q", tt.expectedParams, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/internal/route/1", nil)
+			req.Header.Set("X-Original-URL", tt.value)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestAddPathHeader_TraversalFromSourceHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		blockTraversal bool
+		expectedFlag   string
+		expectedStatus int
+	}{
+		{
+			name:           "Traversal in source header flagged",
+			expectedFlag:   "traversal",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Traversal in source header blocked",
+			blockTraversal: true,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var onGroupPath string
+			cfg := CreateConfig()
+			cfg.SourceHeaders = []string{"X-Original-URL"}
+			cfg.FlagTraversal = true
+			cfg.BlockTraversal = tt.blockTraversal
+			cfg.OnGroup = func(path, group string) {
+				onGroupPath = path
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got := req.Header.Get("X-Path-Suspicious")
+				if got != tt.expectedFlag {
+					t.Errorf("expected suspicious header %q, got %q", tt.expectedFlag, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/internal/route", nil)
+			req.Header.Set("X-Original-URL", "/a/../../etc/passwd")
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rw.Code)
+			}
+			if !tt.blockTraversal && onGroupPath != "/a/../../etc/passwd" {
+				t.Errorf("expected OnGroup to receive the source path, got %q", onGroupPath)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_RiskHeaderReusesClassification(t *testing.T) {
+	tests := []struct {
+		name                 string
+		path                 string
+		templates            []string
+		datadog              bool
+		expected             string
+		expectedUnclassified int
+	}{
+		{
+			name:     "Per-segment detection",
+			path:     "/api/v1/users/42/posts/7",
+			expected: "2",
+		},
+		{
+			name:      "Route template placeholders are not literals",
+			path:      "/api/v1/users/42/posts/7",
+			templates: []string{"/api/v1/users/{id}/posts/{id}"},
+			expected:  "2",
+		},
+		{
+			name:     "Datadog method prefix ignored",
+			path:     "/api/v1/users/42/posts/7",
+			datadog:  true,
+			expected: "2",
+		},
+		{
+			name:                 "Unclassified segment reported once",
+			path:                 "/api/v1/users/x~9Kq2abc",
+			expected:             "2",
+			expectedUnclassified: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unclassified := 0
+			cfg := CreateConfig()
+			cfg.RiskHeaderName = "x-path-risk"
+			cfg.RouteTemplates = tt.templates
+			if tt.datadog {
+				cfg.OutputFormat = outputFormatDatadog
+			}
+			cfg.OnUnclassified = func(segment string) {
+				unclassified++
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-risk"); got != tt.expected {
+					t.Errorf("expected risk score %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if unclassified != tt.expectedUnclassified {
+				t.Errorf("expected OnUnclassified to be called %d times, got %d", tt.expectedUnclassified, unclassified)
+			}
+		})
+	}
+}
+
+func TestAddPathHeader_RiskHeaderScoresClassifiedSegments(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		configure   func(cfg *Config)
+		expected    string
+	}{
+		{
+			name: "Unknown route still scored",
+			path: "/api/v1/users/x~9Kq2abc",
+			configure: func(cfg *Config) {
+				cfg.KnownRouteTemplates = []string{"/health"}
+			},
+			expected: "2",
+		},
+		{
+			name: "Unmatched route template scored on its literals",
+			path: "/api/v1/users/42",
+			configure: func(cfg *Config) {
+				cfg.RouteTemplates = []string{"/health"}
+			},
+			expected: "2",
+		},
+		{
+			name:        "Content type segment not scored",
+			path:        "/api/v1/users/42",
+			contentType: "multipart/form-data; boundary=x",
+			configure: func(cfg *Config) {
+				cfg.ContentTypeGroups = map[string]string{"multipart/form-data": "upload"}
+			},
+			expected: "1",
+		},
+		{
+			name: "Truncation marker not scored",
+			path: "/api/v1/42/43",
+			configure: func(cfg *Config) {
+				cfg.GroupPrefixDepth = 2
+			},
+			expected: "0",
+		},
+		{
+			name: "Truncated literals not scored",
+			path: "/api/v1/users/posts",
+			configure: func(cfg *Config) {
+				cfg.GroupPrefixDepth = 3
+			},
+			expected: "1",
+		},
+		{
+			name: "Action and aggregate tags not scored",
+			path: "/api/v1/orders/42/cancel/count",
+			configure: func(cfg *Config) {
+				cfg.ActionVerbs = []string{"cancel"}
+				cfg.AggregateSuffixes = []string{"count"}
+			},
+			expected: "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.RiskHeaderName = "x-path-risk"
+			tt.configure(cfg)
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("x-path-risk"); got != tt.expected {
+					t.Errorf("expected risk score %q, got %q", tt.expected, got)
+				}
+			})
+
+			handler, err := New(context.Background(), next, cfg, "test-middleware")
+			if err != nil {
+				t.Fatalf("unexpected error creating middleware: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestConfig_ActiveDetectorsLegacyStarMode(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.LegacyStarMode = true
+	cfg.DetectDateParts = true
+
+	enabled := map[string]string{}
+	for _, d := range cfg.ActiveDetectors() {
+		if d.Enabled {
+			enabled[d.Name] = d.Label
+		}
+	}
+
+	for _, name := range []string{"uuid", "numeric_id", "slug"} {
+		if label, ok := enabled[name]; !ok || label != "*" {
+			t.Errorf("expected %s to be enabled with label *, got %q (enabled %v)", name, label, ok)
+		}
+	}
+	for _, name := range []string{"ulid", "cuid", "cuid2", "nanoid", "file", "card_number", "iso_date"} {
+		if _, ok := enabled[name]; ok {
+			t.Errorf("expected %s to be disabled in legacy star mode", name)
+		}
+	}
+	if _, ok := enabled["date_parts"]; !ok {
+		t.Error("expected cross-segment detectors to keep their configuration")
+	}
+
+	profile := cfg.CardinalityProfile()
+	if _, ok := profile["ulid"]; ok {
+		t.Error("expected the cardinality profile to leave out detectors disabled by legacy star mode")
+	}
+}
+
+func TestAddPathHeader_GenericizeIDsOptInLabels(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.GenericizeIDs = true
+	cfg.DetectTraceIDs = true
+	cfg.DetectIBAN = true
+	cfg.DetectHexGroups = true
+	cfg.PrefixLabelMap = map[string]string{"user": "user"}
+
+	expected := "/t/id/s/id/i/id/u/user_id/h/id"
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if got := req.Header.Get("x-path-group"); got != expected {
+			t.Errorf("expected path group %q, got %q", expected, got)
+		}
+	})
+
+	handler, err := New(context.Background(), next, cfg, "test-middleware")
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	path := "/t/4bf92f3577b34da6a3ce929d0e0e4736/s/00f067aa0ba902b7/i/GB82WEST12345698765432" +
+		"/u/user_550e8400-e29b-41d4-a716-446655440000/h/3f2a-9b1c"
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, d := range cfg.ActiveDetectors() {
+		if d.Name == "uuid" && d.Label != labelID {
+			t.Errorf("expected ActiveDetectors to report the generic uuid label, got %q", d.Label)
+		}
+	}
+}
+
+func TestConfig_CardinalityProfileConfiguredDetectors(t *testing.T) {
+	tests := []struct {
+		name         string
+		fraction     float64
+		expectedSafe bool
+	}{
+		{
+			name:         "Every compound part classified",
+			fraction:     1,
+			expectedSafe: true,
+		},
+		{
+			name:         "Literal compound parts kept",
+			fraction:     0.5,
+			expectedSafe: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.CompoundDelimiters = []string{"|"}
+			cfg.CompoundMinFraction = tt.fraction
+			cfg.PaginationKeys = map[string]string{"page": "page_number"}
+			cfg.StructuredIDPatterns = map[string]string{"ticket": `^TCK-\d+$`}
+			profile := cfg.CardinalityProfile()
+
+			if safe, ok := profile["compound"]; !ok || safe != tt.expectedSafe {
+				t.Errorf("expected compound bounded %v, got %v (present %v)", tt.expectedSafe, safe, ok)
+			}
+			if safe, ok := profile["pagination_page"]; !ok || !safe {
+				t.Errorf("expected pagination_page to be bounded, got %v (present %v)", safe, ok)
+			}
+			if safe, ok := profile["structured_pattern_ticket"]; !ok || !safe {
+				t.Errorf("expected structured_pattern_ticket to be bounded, got %v (present %v)", safe, ok)
+			}
+		})
+	}
+}